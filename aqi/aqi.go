@@ -0,0 +1,90 @@
+// Package aqi computes air quality indices from pollutant concentrations.
+package aqi
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Breakpoint maps a range of concentrations onto a range of the index
+type Breakpoint struct {
+	Low, High           float64
+	IndexLow, IndexHigh int
+}
+
+// Index is an air quality index for PM2.5
+type Index struct {
+	Name        string
+	Breakpoints []Breakpoint
+}
+
+// EPA is the US EPA AQI for PM2.5, using the breakpoints revised in 2024
+var EPA = Index{
+	Name: "epa",
+	Breakpoints: []Breakpoint{
+		{0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	},
+}
+
+// China is the Chinese IAQI for PM2.5 as defined in HJ 633-2012
+var China = Index{
+	Name: "china",
+	Breakpoints: []Breakpoint{
+		{0, 35, 0, 50},
+		{35, 75, 50, 100},
+		{75, 115, 100, 150},
+		{115, 150, 150, 200},
+		{150, 250, 200, 300},
+		{250, 350, 300, 400},
+		{350, 500, 400, 500},
+	},
+}
+
+// Indices are the known indices by name
+var Indices = map[string]Index{
+	EPA.Name:   EPA,
+	China.Name: China,
+}
+
+// Lookup returns an index by name
+func Lookup(name string) (Index, error) {
+	idx, ok := Indices[strings.ToLower(name)]
+	if !ok {
+		return Index{}, fmt.Errorf("unknown air quality index %q", name)
+	}
+	return idx, nil
+}
+
+// PM25 returns the index for a PM2.5 concentration in µg/m³. Values beyond
+// the last breakpoint are capped at its highest index value
+func (idx Index) PM25(c float64) int {
+	if len(idx.Breakpoints) == 0 || c < 0 {
+		return 0
+	}
+	// The EPA truncates PM2.5 to one decimal before looking it up
+	c = math.Floor(c*10) / 10
+
+	for i, bp := range idx.Breakpoints {
+		// Breakpoints can leave a gap of 0.1 between them, so anything
+		// below the next Low still belongs to this one
+		next := math.Inf(1)
+		if i+1 < len(idx.Breakpoints) {
+			next = idx.Breakpoints[i+1].Low
+		}
+		if c >= next {
+			continue
+		}
+		if c > bp.High {
+			c = bp.High
+		}
+		v := float64(bp.IndexHigh-bp.IndexLow)/(bp.High-bp.Low)*(c-bp.Low) + float64(bp.IndexLow)
+		return int(math.Round(v))
+	}
+	return idx.Breakpoints[len(idx.Breakpoints)-1].IndexHigh
+}
@@ -0,0 +1,58 @@
+package aqi
+
+import "testing"
+
+func TestPM25(t *testing.T) {
+	tests := []struct {
+		idx  Index
+		c    float64
+		want int
+	}{
+		{EPA, -1, 0},
+		{EPA, 0, 0},
+		{EPA, 9.0, 50},
+		{EPA, 9.05, 50},
+		{EPA, 9.1, 51},
+		{EPA, 22.2, 75},
+		{EPA, 35.4, 100},
+		{EPA, 35.5, 101},
+		{EPA, 55.4, 150},
+		{EPA, 55.5, 151},
+		{EPA, 125.4, 200},
+		{EPA, 125.5, 201},
+		{EPA, 225.4, 300},
+		{EPA, 225.5, 301},
+		{EPA, 325.4, 500},
+		{EPA, 600, 500},
+
+		{China, 0, 0},
+		{China, 34.9, 50},
+		{China, 35, 50},
+		{China, 55, 75},
+		{China, 75, 100},
+		{China, 115, 150},
+		{China, 150, 200},
+		{China, 250, 300},
+		{China, 350, 400},
+		{China, 500, 500},
+		{China, 800, 500},
+
+		{Index{}, 10, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.idx.PM25(tt.c); got != tt.want {
+			t.Errorf("%s.PM25(%v) = %d, want %d", tt.idx.Name, tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"epa", "EPA", "china"} {
+		if _, err := Lookup(name); err != nil {
+			t.Errorf("Lookup(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := Lookup("who"); err == nil {
+		t.Error("Lookup(\"who\") didn't fail")
+	}
+}
@@ -0,0 +1,99 @@
+// Package automation changes the settings of a device on its own, based on
+// the time of day or what the device reports. The bridge checks every
+// automation of a device once a minute and on every status update, and
+// sends whatever they decide to the device.
+package automation
+
+import (
+	"fmt"
+	"time"
+
+	"hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+)
+
+// Automation decides on changes to the settings of a device
+type Automation interface {
+	// Name describes the automation in logs
+	Name() string
+	// Check returns what to do at now, or nil if nothing needs to happen.
+	// state is the last state the device reported, which is nil until it
+	// has
+	Check(now time.Time, state *philips.Reported) *Action
+}
+
+// Limiter is implemented by automations that restrict the commands sent
+// to the device, whether they come from an automation or over MQTT
+type Limiter interface {
+	// Limit returns msg, changed to stay within the limits at now
+	Limit(now time.Time, msg *philips.Desired) *philips.Desired
+}
+
+// Action is what an automation decided on
+type Action struct {
+	// Set is the command to send to the device, if any
+	Set *philips.Desired
+	// Alert is published as an alert, if set
+	Alert string
+	// Cleared is set when the reason for an earlier Alert went away
+	Cleared bool
+}
+
+// FromConfig returns the automations configured for a device. loc is the
+// Location from the configuration file, which can be nil
+func FromConfig(d *config.Device, loc *config.Location) ([]Automation, error) {
+	var res []Automation
+	for i, s := range d.Schedules {
+		sched, err := NewSchedule(s.Days, s.At, s.Set)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %d: %w", i+1, err)
+		}
+		res = append(res, sched)
+	}
+	for i, r := range d.Rules {
+		rule, err := NewRule(r.When, r.For, r.Set, r.Alert)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		res = append(res, rule)
+	}
+	if d.Night != nil {
+		if loc == nil {
+			return nil, fmt.Errorf("night mode needs the location in the configuration file")
+		}
+		night, err := NewNightMode(loc.Latitude, loc.Longitude, d.Night.Set)
+		if err != nil {
+			return nil, fmt.Errorf("night mode: %w", err)
+		}
+		res = append(res, night)
+	}
+	if h := d.Humidity; h != nil {
+		ctl, err := NewHumidityControl(h.Low, h.High, h.Fan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ctl)
+	}
+	if bc := d.Boost; bc != nil {
+		var hold time.Duration
+		if bc.For != "" {
+			var err error
+			if hold, err = time.ParseDuration(bc.For); err != nil {
+				return nil, fmt.Errorf("invalid boost duration %q: %w", bc.For, err)
+			}
+		}
+		boost, err := NewBoost(bc.Above, bc.Below, hold, bc.Set)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, boost)
+	}
+	for i, qc := range d.QuietHours {
+		quiet, err := NewQuietHours(qc.Days, qc.From, qc.To, qc.MaxFan)
+		if err != nil {
+			return nil, fmt.Errorf("quiet hours %d: %w", i+1, err)
+		}
+		res = append(res, quiet)
+	}
+	return res, nil
+}
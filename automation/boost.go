@@ -0,0 +1,92 @@
+package automation
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// DefaultBoost are the settings Boost uses if none are given
+var DefaultBoost = philips.Settings{"mode": "manual", "fan": "turbo"}
+
+// Boost changes settings while PM2.5 is high, and puts them back the way
+// they were once it has been low for a while
+type Boost struct {
+	above, below int
+	hold         time.Duration
+	settings     philips.Settings
+	msg          *philips.Desired
+
+	mu sync.Mutex
+	// active is set while boosting, saved is what the settings were
+	// before. low is since when PM2.5 has been below the low mark
+	active bool
+	saved  philips.Settings
+	low    time.Time
+}
+
+// NewBoost returns a Boost that starts once PM2.5 is above above, and ends
+// once it has been below below for hold. Empty settings use DefaultBoost
+func NewBoost(above, below int, hold time.Duration, settings philips.Settings) (*Boost, error) {
+	if below > above {
+		return nil, fmt.Errorf("boost ends at %d, which is above where it starts at %d", below, above)
+	}
+	if len(settings) == 0 {
+		settings = DefaultBoost
+	}
+
+	b := &Boost{above: above, below: below, hold: hold, settings: philips.Settings{}}
+	for name, v := range settings {
+		b.settings[strings.ToLower(name)] = v
+	}
+	var err error
+	if b.msg, err = b.settings.Desired(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Name returns the thresholds of the boost
+func (b *Boost) Name() string {
+	return fmt.Sprintf("boost above %d", b.above)
+}
+
+// Check starts boosting when PM2.5 crosses the high mark, and stops once
+// it has stayed below the low mark long enough
+func (b *Boost) Check(now time.Time, state *philips.Reported) *Action {
+	if state == nil || state.Power != philips.On {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pm25 := state.ParticulateMatter25
+	if !b.active {
+		if pm25 <= b.above {
+			return nil
+		}
+		b.active, b.low = true, time.Time{}
+		b.saved = current(state, b.settings)
+		return &Action{Set: b.msg}
+	}
+
+	if pm25 >= b.below {
+		b.low = time.Time{}
+		return nil
+	}
+	if b.low.IsZero() {
+		b.low = now
+	}
+	if now.Sub(b.low) < b.hold {
+		return nil
+	}
+	b.active = false
+	if msg := restore(b.saved); msg != nil {
+		return &Action{Set: msg}
+	}
+	return nil
+}
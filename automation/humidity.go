@@ -0,0 +1,101 @@
+package automation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// retryAfter is how long an automation waits for the device to report a
+// change it sent before sending it again
+const retryAfter = 2 * time.Minute
+
+// HumidityControl keeps the relative humidity between a low and high mark,
+// by humidifying below the low mark until it reaches the high one. The
+// device's own target tends to overshoot in small rooms, since it only
+// stops once the target is reached
+type HumidityControl struct {
+	low, high int
+	fan       philips.Settings
+
+	mu sync.Mutex
+	// sent is when humidification was last switched, to humidify
+	sent     time.Time
+	humidify bool
+	saved    philips.Settings
+}
+
+// NewHumidityControl returns a HumidityControl for the band between low
+// and high percent. fan is the fan speed to use while humidifying, empty
+// leaves it alone
+func NewHumidityControl(low, high int, fan string) (*HumidityControl, error) {
+	if low < 0 || high > 100 || low >= high {
+		return nil, fmt.Errorf("invalid humidity band %d-%d%%", low, high)
+	}
+	h := &HumidityControl{low: low, high: high}
+	if fan != "" {
+		// The fan speed can only be changed in manual mode
+		h.fan = philips.Settings{"mode": "manual", "fan": fan}
+		if _, err := h.fan.Desired(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Name returns the humidity band
+func (h *HumidityControl) Name() string {
+	return fmt.Sprintf("humidity %d-%d%%", h.low, h.high)
+}
+
+// Check switches humidification on below the band and off above it
+func (h *HumidityControl) Check(now time.Time, state *philips.Reported) *Action {
+	if state == nil || state.Power != philips.On {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	humidifying := state.Function == philips.PurificationHumidification
+	var humidify bool
+	switch {
+	case state.RelativeHumidity < h.low:
+		humidify = true
+	case state.RelativeHumidity >= h.high:
+		humidify = false
+	default:
+		// Within the band, keep doing what we're doing
+		return nil
+	}
+	if humidify == humidifying {
+		return nil
+	}
+	if humidify == h.humidify && now.Sub(h.sent) < retryAfter {
+		return nil
+	}
+	h.sent, h.humidify = now, humidify
+
+	if humidify {
+		f := philips.PurificationHumidification
+		// Let the device humidify as far as it will, we decide when to stop
+		msg := &philips.Desired{Function: &f, RelativeHumidityTarget: philips.IntP(70)}
+		if h.fan != nil {
+			h.saved = current(state, h.fan)
+			m, _ := h.fan.Desired()
+			msg.Mode, msg.FanSpeed = m.Mode, m.FanSpeed
+		}
+		return &Action{Set: msg}
+	}
+
+	msg := &philips.Desired{}
+	if saved := restore(h.saved); saved != nil {
+		msg = saved
+	}
+	h.saved = nil
+	f := philips.Purification
+	msg.Function = &f
+	return &Action{Set: msg}
+}
@@ -0,0 +1,85 @@
+package automation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+func TestHumidityControl(t *testing.T) {
+	h, err := NewHumidityControl(40, 50, "silent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := func(rh int, f philips.Function) *philips.Reported {
+		return &philips.Reported{
+			Power:            philips.On,
+			Mode:             philips.Auto,
+			FanSpeed:         philips.Speed2,
+			Function:         f,
+			RelativeHumidity: rh,
+		}
+	}
+	ph, p := philips.PurificationHumidification, philips.Purification
+	manual, auto := philips.Manual, philips.Auto
+	silent, speed2 := philips.Silent, philips.Speed2
+	start := &philips.Desired{
+		Function:               &ph,
+		RelativeHumidityTarget: philips.IntP(70),
+		Mode:                   &manual,
+		FanSpeed:               &silent,
+	}
+	stop := &philips.Desired{Function: &p, Mode: &auto, FanSpeed: &speed2}
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		name  string
+		after time.Duration
+		state *philips.Reported
+		want  *philips.Desired
+	}{
+		{"inside the band", 0, reported(45, p), nil},
+		{"powered off", 0, &philips.Reported{Power: philips.Off, RelativeHumidity: 30}, nil},
+		{"below the band", 0, reported(39, p), start},
+		{"waiting for the device", time.Minute, reported(39, p), nil},
+		{"device didn't follow", 3 * time.Minute, reported(39, p), start},
+		{"humidifying inside the band", 4 * time.Minute, reported(45, ph), nil},
+		{"just below the high mark", 5 * time.Minute, reported(49, ph), nil},
+		{"at the high mark", 6 * time.Minute, reported(50, ph), stop},
+		{"purifying inside the band", 7 * time.Minute, reported(45, p), nil},
+		{"at the low mark", 8 * time.Minute, reported(40, p), nil},
+	}
+	for _, s := range steps {
+		var got *philips.Desired
+		if a := h.Check(t0.Add(s.after), s.state); a != nil {
+			got = a.Set
+		}
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: sent %+v, want %+v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestNewHumidityControl(t *testing.T) {
+	tests := []struct {
+		low, high int
+		fan       string
+		err       bool
+	}{
+		{40, 50, "", false},
+		{40, 50, "turbo", false},
+		{50, 50, "", true},
+		{60, 50, "", true},
+		{-1, 50, "", true},
+		{40, 101, "", true},
+		{40, 50, "fast", true},
+	}
+	for _, tt := range tests {
+		_, err := NewHumidityControl(tt.low, tt.high, tt.fan)
+		if (err != nil) != tt.err {
+			t.Errorf("NewHumidityControl(%d, %d, %q) error = %v, want error %t", tt.low, tt.high, tt.fan, err, tt.err)
+		}
+	}
+}
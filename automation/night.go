@@ -0,0 +1,85 @@
+package automation
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// DefaultNight are the settings NightMode uses if none are given
+var DefaultNight = philips.Settings{"mode": "sleep", "brightness": "off"}
+
+// NightMode changes settings at sunset, and puts them back the way they
+// were at sunrise
+type NightMode struct {
+	latitude  float64
+	longitude float64
+	settings  philips.Settings
+	msg       *philips.Desired
+
+	mu sync.Mutex
+	// active is set between sunset and sunrise, once the settings were
+	// changed. saved is what they were before
+	active bool
+	saved  philips.Settings
+}
+
+// NewNightMode returns a NightMode for a location, in degrees with east and
+// north positive. Empty settings use DefaultNight
+func NewNightMode(latitude, longitude float64, settings philips.Settings) (*NightMode, error) {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return nil, fmt.Errorf("invalid location %g,%g", latitude, longitude)
+	}
+	if len(settings) == 0 {
+		settings = DefaultNight
+	}
+
+	n := &NightMode{latitude: latitude, longitude: longitude, settings: philips.Settings{}}
+	for name, v := range settings {
+		n.settings[strings.ToLower(name)] = v
+	}
+	var err error
+	if n.msg, err = n.settings.Desired(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Name returns "night mode"
+func (n *NightMode) Name() string {
+	return "night mode"
+}
+
+// Check changes the settings once the sun has set, and restores them once
+// it has risen
+func (n *NightMode) Check(now time.Time, state *philips.Reported) *Action {
+	if state == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rise, set, ok := Sun(now, n.latitude, n.longitude)
+	if !ok {
+		// Midnight sun or polar night, stay as we are
+		return nil
+	}
+	night := now.Before(rise) || !now.Before(set)
+
+	switch {
+	case night && !n.active:
+		n.active = true
+		n.saved = current(state, n.settings)
+		return &Action{Set: n.msg}
+	case !night && n.active:
+		n.active = false
+		if msg := restore(n.saved); msg != nil {
+			return &Action{Set: msg}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package automation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+func TestNightMode(t *testing.T) {
+	n, err := NewNightMode(51.5074, -0.1278, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := &philips.Reported{Mode: philips.Auto, Brightness: philips.Brightness100}
+	night := &philips.Reported{Mode: philips.Sleep, Brightness: philips.Brightness0}
+	sleep, auto := philips.Sleep, philips.Auto
+	off, on := philips.Brightness0, philips.Brightness100
+
+	// Sunrise in London is at 03:43 and sunset at 20:21
+	steps := []struct {
+		name  string
+		now   time.Time
+		state *philips.Reported
+		want  *philips.Desired
+	}{
+		{"afternoon", time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), day, nil},
+		{"no state", time.Date(2024, 6, 21, 21, 0, 0, 0, time.UTC), nil, nil},
+		{"after sunset", time.Date(2024, 6, 21, 21, 0, 0, 0, time.UTC), day, &philips.Desired{Mode: &sleep, Brightness: &off}},
+		{"late evening", time.Date(2024, 6, 21, 23, 0, 0, 0, time.UTC), night, nil},
+		{"before sunrise", time.Date(2024, 6, 22, 2, 0, 0, 0, time.UTC), night, nil},
+		{"after sunrise", time.Date(2024, 6, 22, 9, 0, 0, 0, time.UTC), night, &philips.Desired{Mode: &auto, Brightness: &on}},
+		{"morning", time.Date(2024, 6, 22, 10, 0, 0, 0, time.UTC), day, nil},
+	}
+	for _, s := range steps {
+		var got *philips.Desired
+		if a := n.Check(s.now, s.state); a != nil {
+			got = a.Set
+		}
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: sent %+v, want %+v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestNewNightMode(t *testing.T) {
+	tests := []struct {
+		latitude, longitude float64
+		settings            philips.Settings
+		err                 bool
+	}{
+		{59.3293, 18.0686, nil, false},
+		{59.3293, 18.0686, philips.Settings{"Mode": "night"}, false},
+		{91, 0, nil, true},
+		{0, -181, nil, true},
+		{59.3293, 18.0686, philips.Settings{"mode": "dark"}, true},
+	}
+	for _, tt := range tests {
+		_, err := NewNightMode(tt.latitude, tt.longitude, tt.settings)
+		if (err != nil) != tt.err {
+			t.Errorf("NewNightMode(%g, %g, %v) error = %v, want error %t", tt.latitude, tt.longitude, tt.settings, err, tt.err)
+		}
+	}
+}
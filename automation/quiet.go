@@ -0,0 +1,131 @@
+package automation
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// QuietHours caps the fan speed during a time window. Commands sent during
+// it are capped, and if the device speeds up on its own in an automatic
+// mode it's switched to manual at the cap. The mode and fan speed are put
+// back once the window ends
+type QuietHours struct {
+	days     [7]bool
+	from, to int
+	spec     string
+	max      philips.FanSpeed
+
+	mu sync.Mutex
+	// overridden is set once the device was slowed down during the
+	// window, saved is what the settings were before
+	overridden bool
+	saved      philips.Settings
+	sent       time.Time
+}
+
+// NewQuietHours returns QuietHours between from and to, as HH:MM in local
+// time, on days like NewSchedule takes them. A window that ends before it
+// starts runs past midnight, and belongs to the day it started on. max is
+// the fastest fan speed allowed, like the fan subcommand of control takes
+func NewQuietHours(days, from, to, max string) (*QuietHours, error) {
+	q := &QuietHours{spec: strings.TrimSpace(days + " " + from + "-" + to)}
+
+	var err error
+	if q.days, err = parseDays(days); err != nil {
+		return nil, err
+	}
+	if q.from, err = minuteOfDay(from); err != nil {
+		return nil, err
+	}
+	if q.to, err = minuteOfDay(to); err != nil {
+		return nil, err
+	}
+	if q.from == q.to {
+		return nil, fmt.Errorf("quiet hours from %s to %s are empty", from, to)
+	}
+
+	msg := &philips.Desired{}
+	if err := msg.Apply("fan", max); err != nil {
+		return nil, err
+	}
+	q.max = *msg.FanSpeed
+	return q, nil
+}
+
+// minuteOfDay parses HH:MM into minutes since midnight
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Name returns the window of the quiet hours
+func (q *QuietHours) Name() string {
+	return "quiet hours " + q.spec
+}
+
+// active reports if now is within the window
+func (q *QuietHours) active(now time.Time) bool {
+	m := now.Hour()*60 + now.Minute()
+	if q.from < q.to {
+		return q.days[now.Weekday()] && m >= q.from && m < q.to
+	}
+	yesterday := (now.Weekday() + 6) % 7
+	return (q.days[now.Weekday()] && m >= q.from) || (q.days[yesterday] && m < q.to)
+}
+
+// Limit caps the fan speed of a command during the window
+func (q *QuietHours) Limit(now time.Time, msg *philips.Desired) *philips.Desired {
+	if msg.FanSpeed == nil || msg.FanSpeed.Step() <= q.max.Step() || !q.active(now) {
+		return msg
+	}
+	capped := *msg
+	capped.FanSpeed = &q.max
+	return &capped
+}
+
+// Check slows the device down when it runs faster than allowed during the
+// window, and puts it back once the window ends
+func (q *QuietHours) Check(now time.Time, state *philips.Reported) *Action {
+	if state == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.active(now) {
+		if !q.overridden {
+			return nil
+		}
+		q.overridden = false
+		if msg := restore(q.saved); msg != nil {
+			return &Action{Set: msg}
+		}
+		return nil
+	}
+
+	if state.Power != philips.On || state.FanSpeed.Step() <= q.max.Step() {
+		return nil
+	}
+	if q.overridden && now.Sub(q.sent) < retryAfter {
+		return nil
+	}
+	if !q.overridden {
+		q.overridden = true
+		q.saved = current(state, philips.Settings{"mode": "", "fan": ""})
+		// Outside of manual mode the device picks the fan speed itself
+		if q.saved["mode"] != "manual" {
+			delete(q.saved, "fan")
+		}
+	}
+	q.sent = now
+	m := philips.Manual
+	return &Action{Set: &philips.Desired{Mode: &m, FanSpeed: &q.max}}
+}
@@ -0,0 +1,32 @@
+package automation
+
+import (
+	"hemtjan.st/klimat/philips"
+)
+
+// current returns what the settings named in s are set to now, so an
+// automation that changes them can put them back afterwards
+func current(state *philips.Reported, s philips.Settings) philips.Settings {
+	all := state.Settings()
+	res := philips.Settings{}
+	for name := range s {
+		if v, ok := all[name]; ok {
+			res[name] = v
+		}
+	}
+	return res
+}
+
+// restore returns the command that puts back settings saved with current,
+// or nil if there's nothing to put back
+func restore(saved philips.Settings) *philips.Desired {
+	if len(saved) == 0 {
+		return nil
+	}
+	// The values came from Reported.Settings, so they're always valid
+	msg, err := saved.Desired()
+	if err != nil {
+		return nil
+	}
+	return msg
+}
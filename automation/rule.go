@@ -0,0 +1,153 @@
+package automation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// readings are the values of a reported state rules can use, by name.
+// Most have a short alias matching the field in the status JSON
+var readings = map[string]func(*philips.Reported) float64{
+	"pm25":        func(r *philips.Reported) float64 { return float64(r.ParticulateMatter25) },
+	"humidity":    func(r *philips.Reported) float64 { return float64(r.RelativeHumidity) },
+	"rh":          func(r *philips.Reported) float64 { return float64(r.RelativeHumidity) },
+	"temperature": func(r *philips.Reported) float64 { return float64(r.Temperature) },
+	"temp":        func(r *philips.Reported) float64 { return float64(r.Temperature) },
+	"iaq":         func(r *philips.Reported) float64 { return float64(r.AirQuality) },
+	"iaql":        func(r *philips.Reported) float64 { return float64(r.AirQuality) },
+	"water":       func(r *philips.Reported) float64 { return float64(r.WaterLevel) },
+	"wl":          func(r *philips.Reported) float64 { return float64(r.WaterLevel) },
+}
+
+// operators are the comparisons a condition can use. Longer ones come
+// first, so >= isn't taken for >
+var operators = []struct {
+	op string
+	fn func(a, b float64) bool
+}{
+	{">=", func(a, b float64) bool { return a >= b }},
+	{"<=", func(a, b float64) bool { return a <= b }},
+	{"==", func(a, b float64) bool { return a == b }},
+	{"!=", func(a, b float64) bool { return a != b }},
+	{">", func(a, b float64) bool { return a > b }},
+	{"<", func(a, b float64) bool { return a < b }},
+}
+
+// Condition compares a reading to a threshold
+type Condition struct {
+	reading   func(*philips.Reported) float64
+	compare   func(a, b float64) bool
+	threshold float64
+}
+
+// ParseCondition parses a condition like "pm25 > 35" or "rh < 35%"
+func ParseCondition(s string) (Condition, error) {
+	for _, o := range operators {
+		i := strings.Index(s, o.op)
+		if i < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		reading, ok := readings[name]
+		if !ok {
+			return Condition{}, fmt.Errorf("unknown reading %q in %q", name, s)
+		}
+		value := strings.TrimSuffix(strings.TrimSpace(s[i+len(o.op):]), "%")
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return Condition{}, fmt.Errorf("invalid threshold in %q: %w", s, err)
+		}
+		return Condition{reading: reading, compare: o.fn, threshold: threshold}, nil
+	}
+	return Condition{}, fmt.Errorf("invalid condition %q, expected something like pm25 > 35", s)
+}
+
+// Holds reports if the condition is true for a state
+func (c Condition) Holds(state *philips.Reported) bool {
+	return c.compare(c.reading(state), c.threshold)
+}
+
+// Rule changes settings and raises an alert once a condition held for a
+// while. It triggers again after the condition stopped holding
+type Rule struct {
+	name  string
+	cond  Condition
+	hold  time.Duration
+	msg   *philips.Desired
+	alert string
+
+	mu sync.Mutex
+	// since is when the condition started holding, zero if it doesn't
+	since     time.Time
+	triggered bool
+}
+
+// NewRule returns a rule that applies settings and raises alert once when
+// has held for hold, like "5m". Either settings or alert can be empty, but
+// not both
+func NewRule(when, hold string, settings philips.Settings, alert string) (*Rule, error) {
+	r := &Rule{name: "rule " + strings.TrimSpace(when), alert: alert}
+
+	var err error
+	if r.cond, err = ParseCondition(when); err != nil {
+		return nil, err
+	}
+	if hold != "" {
+		if r.hold, err = time.ParseDuration(hold); err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %w", hold, err)
+		}
+		r.name += " for " + hold
+	}
+
+	if len(settings) == 0 && alert == "" {
+		return nil, fmt.Errorf("%s does nothing, it needs settings or an alert", r.name)
+	}
+	if len(settings) > 0 {
+		if r.msg, err = settings.Desired(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Name returns the condition of the rule
+func (r *Rule) Name() string {
+	return r.name
+}
+
+// Check triggers the rule once the condition held long enough, and clears
+// its alert once it no longer does
+func (r *Rule) Check(now time.Time, state *philips.Reported) *Action {
+	if state == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.cond.Holds(state) {
+		r.since = time.Time{}
+		if !r.triggered {
+			return nil
+		}
+		r.triggered = false
+		if r.alert == "" {
+			return nil
+		}
+		return &Action{Alert: r.alert, Cleared: true}
+	}
+
+	if r.since.IsZero() {
+		r.since = now
+	}
+	if r.triggered || now.Sub(r.since) < r.hold {
+		return nil
+	}
+	r.triggered = true
+	return &Action{Set: r.msg, Alert: r.alert}
+}
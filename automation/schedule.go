@@ -0,0 +1,119 @@
+package automation
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// maxCatchUp is how far back a schedule still fires when it wasn't checked
+// at the time it was due. Anything older, like after the machine was
+// suspended, is skipped
+const maxCatchUp = 5 * time.Minute
+
+// Schedule changes settings at a time of day, on certain days of the week
+type Schedule struct {
+	days   [7]bool
+	hour   int
+	minute int
+	spec   string
+	msg    *philips.Desired
+
+	mu sync.Mutex
+	// last is when the schedule was last checked
+	last time.Time
+}
+
+// NewSchedule returns a schedule that applies settings at, as HH:MM in
+// local time, on days. Days is daily, weekdays, weekends or a comma
+// separated list of days like mon,wed,fri, and empty means daily
+func NewSchedule(days, at string, settings philips.Settings) (*Schedule, error) {
+	s := &Schedule{spec: strings.TrimSpace(days + " " + at)}
+
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q, expected HH:MM", at)
+	}
+	s.hour, s.minute = t.Hour(), t.Minute()
+
+	if s.days, err = parseDays(days); err != nil {
+		return nil, err
+	}
+
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("no settings to change at %s", at)
+	}
+	if s.msg, err = settings.Desired(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseDays returns the weekdays, indexed by time.Weekday, a days spec
+// covers
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	switch strings.ToLower(strings.TrimSpace(spec)) {
+	case "", "daily":
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	case "weekdays":
+		for d := time.Monday; d <= time.Friday; d++ {
+			days[d] = true
+		}
+		return days, nil
+	case "weekends":
+		days[time.Saturday], days[time.Sunday] = true, true
+		return days, nil
+	}
+
+	names := map[string]time.Weekday{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		names[strings.ToLower(d.String()[:3])] = d
+	}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		d, ok := names[name]
+		if !ok {
+			return days, fmt.Errorf("invalid day %q", name)
+		}
+		days[d] = true
+	}
+	return days, nil
+}
+
+// Name returns the days and time of the schedule
+func (s *Schedule) Name() string {
+	return "schedule " + s.spec
+}
+
+// Check returns the settings of the schedule if it was due since the last
+// time it was checked
+func (s *Schedule) Check(now time.Time, _ *philips.Reported) *Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.last
+	s.last = now
+	if last.IsZero() || !now.After(last) || now.Sub(last) > maxCatchUp {
+		return nil
+	}
+
+	// The time it was due can be on the day before now, right after
+	// midnight
+	for _, day := range []time.Time{last, now} {
+		due := time.Date(day.Year(), day.Month(), day.Day(), s.hour, s.minute, 0, 0, now.Location())
+		if s.days[due.Weekday()] && due.After(last) && !due.After(now) {
+			return &Action{Set: s.msg}
+		}
+	}
+	return nil
+}
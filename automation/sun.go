@@ -0,0 +1,51 @@
+package automation
+
+import (
+	"math"
+	"time"
+)
+
+// Sun returns when the sun rises and sets on the day of t, at latitude and
+// longitude in degrees, with east and north positive. It uses the sunrise
+// equation, which is good to about a minute away from the poles. ok is
+// false when the sun doesn't rise or set that day
+func Sun(t time.Time, latitude, longitude float64) (rise, set time.Time, ok bool) {
+	const rad = math.Pi / 180
+
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, time.UTC)
+	// Days since the J2000 epoch, at noon
+	n := math.Round(julian(noon) - 2451545.0 + 0.0008)
+	// Mean solar noon
+	j := n - longitude/360
+	// Solar mean anomaly
+	m := math.Mod(357.5291+0.98560028*j, 360)
+	// Equation of the center
+	c := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	// Ecliptic longitude
+	l := math.Mod(m+c+180+102.9372, 360)
+	transit := 2451545.0 + j + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*l*rad)
+	// Declination of the sun
+	sinDecl := math.Sin(l*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+
+	cosHour := (math.Sin(-0.833*rad) - math.Sin(latitude*rad)*sinDecl) / (math.Cos(latitude*rad) * cosDecl)
+	// Written this way round to also catch NaN
+	if !(cosHour >= -1 && cosHour <= 1) {
+		return time.Time{}, time.Time{}, false
+	}
+	hour := math.Acos(cosHour) / rad
+
+	rise = fromJulian(transit - hour/360).In(t.Location())
+	set = fromJulian(transit + hour/360).In(t.Location())
+	return rise, set, true
+}
+
+// julian returns the Julian date of t
+func julian(t time.Time) float64 {
+	return float64(t.Unix())/86400 + 2440587.5
+}
+
+// fromJulian returns the time of a Julian date
+func fromJulian(j float64) time.Time {
+	return time.Unix(int64(math.Round((j-2440587.5)*86400)), 0)
+}
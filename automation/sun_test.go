@@ -0,0 +1,80 @@
+package automation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSun(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name                string
+		day                 time.Time
+		latitude, longitude float64
+		rise, set           time.Time
+	}{
+		{
+			name:     "London, midsummer",
+			day:      time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC),
+			latitude: 51.5074, longitude: -0.1278,
+			rise: time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC),
+			set:  time.Date(2024, 6, 21, 20, 21, 0, 0, time.UTC),
+		},
+		{
+			name:     "Stockholm, midwinter",
+			day:      time.Date(2024, 12, 21, 0, 0, 0, 0, cet),
+			latitude: 59.3293, longitude: 18.0686,
+			rise: time.Date(2024, 12, 21, 8, 43, 0, 0, cet),
+			set:  time.Date(2024, 12, 21, 14, 48, 0, 0, cet),
+		},
+		{
+			name:     "New York, new year",
+			day:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			latitude: 40.7128, longitude: -74.0060,
+			rise: time.Date(2024, 1, 1, 12, 20, 0, 0, time.UTC),
+			set:  time.Date(2024, 1, 1, 21, 39, 0, 0, time.UTC),
+		},
+		{
+			name:     "Sydney, midwinter",
+			day:      time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC),
+			latitude: -33.8688, longitude: 151.2093,
+			rise: time.Date(2024, 6, 20, 21, 0, 0, 0, time.UTC),
+			set:  time.Date(2024, 6, 21, 6, 54, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		rise, set, ok := Sun(tt.day, tt.latitude, tt.longitude)
+		if !ok {
+			t.Errorf("%s: no sunrise or sunset", tt.name)
+			continue
+		}
+		if d := rise.Sub(tt.rise).Abs(); d > 3*time.Minute {
+			t.Errorf("%s: sunrise at %s, want %s", tt.name, rise, tt.rise)
+		}
+		if d := set.Sub(tt.set).Abs(); d > 3*time.Minute {
+			t.Errorf("%s: sunset at %s, want %s", tt.name, set, tt.set)
+		}
+		if rise.Location() != tt.day.Location() {
+			t.Errorf("%s: sunrise in %s, want %s", tt.name, rise.Location(), tt.day.Location())
+		}
+	}
+}
+
+func TestSunPolar(t *testing.T) {
+	tests := []struct {
+		name                string
+		day                 time.Time
+		latitude, longitude float64
+	}{
+		{"Tromsø, polar day", time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 69.6492, 18.9553},
+		{"Tromsø, polar night", time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 69.6492, 18.9553},
+		{"South pole, polar night", time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), -90, 0},
+		{"North pole, polar day", time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 90, 0},
+	}
+	for _, tt := range tests {
+		rise, set, ok := Sun(tt.day, tt.latitude, tt.longitude)
+		if ok || !rise.IsZero() || !set.IsZero() {
+			t.Errorf("%s: got sunrise %s and sunset %s, want none", tt.name, rise, set)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// Sources an AirQualityMapping can be based on
+const (
+	// SourceIAI uses the indoor allergen index the device reports
+	SourceIAI = "iai"
+	// SourcePM25 uses the PM2.5 density in µg/m³
+	SourcePM25 = "pm25"
+)
+
+// AirQualityMapping turns the state a device reports into a HomeKit air
+// quality, from 1 (excellent) to 5 (poor)
+type AirQualityMapping struct {
+	// Source is the value the mapping is based on, SourceIAI or
+	// SourcePM25
+	Source string
+	// Thresholds are the highest values that are still excellent, good,
+	// fair and inferior. Anything above the last one is poor
+	Thresholds []int
+}
+
+// Presets for AirQualityMapping
+var (
+	// AirQualityIAI matches the colours of the ring around the air quality
+	// display, and is what the bridge has always published
+	AirQualityIAI = AirQualityMapping{Source: SourceIAI, Thresholds: []int{1, 3, 6, 9}}
+	// AirQualityPM25 is based on the WHO guidelines for PM2.5
+	AirQualityPM25 = AirQualityMapping{Source: SourcePM25, Thresholds: []int{10, 25, 50, 75}}
+	// AirQualityEPA follows the bands of the US EPA AQI for PM2.5, with
+	// unhealthy and worse all being poor
+	AirQualityEPA = AirQualityMapping{Source: SourcePM25, Thresholds: []int{12, 35, 55, 150}}
+)
+
+// ParseAirQualityMapping parses one of the presets iai, pm25 or epa, or a
+// custom mapping as the source followed by four thresholds, like
+// pm25:10,20,30,40
+func ParseAirQualityMapping(s string) (AirQualityMapping, error) {
+	switch strings.ToLower(s) {
+	case "", "iai":
+		return AirQualityIAI, nil
+	case "pm25":
+		return AirQualityPM25, nil
+	case "epa":
+		return AirQualityEPA, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return AirQualityMapping{}, fmt.Errorf("unknown air quality mapping %q, use iai, pm25, epa or <source>:<thresholds>", s)
+	}
+	m := AirQualityMapping{Source: strings.ToLower(parts[0])}
+	if m.Source != SourceIAI && m.Source != SourcePM25 {
+		return AirQualityMapping{}, fmt.Errorf("unknown air quality source %q, use iai or pm25", parts[0])
+	}
+	for _, v := range strings.Split(parts[1], ",") {
+		t, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return AirQualityMapping{}, fmt.Errorf("invalid air quality threshold %q: %w", v, err)
+		}
+		if n := len(m.Thresholds); n > 0 && t <= m.Thresholds[n-1] {
+			return AirQualityMapping{}, fmt.Errorf("air quality thresholds must be increasing")
+		}
+		m.Thresholds = append(m.Thresholds, t)
+	}
+	if len(m.Thresholds) != 4 {
+		return AirQualityMapping{}, fmt.Errorf("need 4 air quality thresholds, got %d", len(m.Thresholds))
+	}
+	return m, nil
+}
+
+// Map returns the HomeKit air quality for a reported state
+func (m AirQualityMapping) Map(r *philips.Reported) string {
+	if m.Thresholds == nil {
+		return r.AirQuality.ToHemtjanst()
+	}
+
+	v := int(r.AirQuality)
+	if m.Source == SourcePM25 {
+		v = r.ParticulateMatter25
+	} else if v < 1 {
+		// Same as AirQuality.ToHemtjanst, nothing reported is poor
+		return "5"
+	}
+	for i, t := range m.Thresholds {
+		if v <= t {
+			return strconv.Itoa(i + 1)
+		}
+	}
+	return "5"
+}
@@ -0,0 +1,134 @@
+package bridge
+
+import (
+	"encoding/json"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// Severities of an Alert
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Events an Alert can be about
+const (
+	// EventError is any error the device reports, other than the tank
+	// being empty
+	EventError = "error"
+	// EventTankEmpty means the water tank needs refilling
+	EventTankEmpty = "tank_empty"
+	// EventFilter means one of the filters needs cleaning or replacing
+	EventFilter = "filter"
+	// EventOffline means the device stopped sending notifications
+	EventOffline = "offline"
+	// EventFirmware means the firmware of the device was updated
+	EventFirmware = "firmware"
+	// EventAutomation is raised by an automation, like a rule from the
+	// configuration file
+	EventAutomation = "automation"
+)
+
+// Alert is published on <topic>/alerts when the device starts or stops
+// reporting an error, a filter becomes due, the device goes offline or an
+// automation raises one
+type Alert struct {
+	DeviceID string    `json:"device_id"`
+	Event    string    `json:"event"`
+	Code     int       `json:"code,omitempty"`
+	Message  string    `json:"message"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"timestamp"`
+	// Cleared is set when the device stopped reporting the error
+	Cleared bool `json:"cleared"`
+}
+
+// severity returns how bad an error is. The ones we know about need the
+// user to do something, but the device keeps working
+func severity(code philips.ErrorCode) string {
+	switch code {
+	case philips.ErrCleanFilter, philips.ErrNoWater, philips.ErrWaterTankOpen:
+		return SeverityWarning
+	default:
+		return SeverityError
+	}
+}
+
+// errorAlert returns the alert for an error code
+func errorAlert(code philips.ErrorCode, cleared bool) Alert {
+	event := EventError
+	if code == philips.ErrNoWater {
+		event = EventTankEmpty
+	}
+	return Alert{
+		Event:    event,
+		Code:     int(code),
+		Message:  code.Description(),
+		Severity: severity(code),
+		Time:     time.Now(),
+		Cleared:  cleared,
+	}
+}
+
+// checkAlerts publishes alerts when the error the device reports, or
+// whether a filter is due, changed since the last update
+func (b *Bridge) checkAlerts(update *philips.Reported) {
+	due := filterDue(update)
+
+	b.mu.Lock()
+	prev, prevDue, known := b.lastErr, b.filterDue, b.errSeen
+	b.lastErr, b.filterDue, b.errSeen = update.Err, due, true
+	b.mu.Unlock()
+
+	if !known || prev != update.Err {
+		if known && prev != 0 {
+			b.alert(errorAlert(prev, true))
+		}
+		if update.Err != 0 {
+			b.alert(errorAlert(update.Err, false))
+		}
+	}
+
+	if (!known && due) || (known && due != prevDue) {
+		b.alert(Alert{
+			Event:    EventFilter,
+			Message:  "one of the filters needs cleaning or replacing",
+			Severity: SeverityWarning,
+			Time:     time.Now(),
+			Cleared:  !due,
+		})
+	}
+}
+
+// availabilityAlert publishes an alert when the device went offline, or
+// came back
+func (b *Bridge) availabilityAlert(online bool) {
+	msg := "device is offline"
+	if online {
+		msg = "device is back online"
+	}
+	b.alert(Alert{
+		Event:    EventOffline,
+		Message:  msg,
+		Severity: SeverityWarning,
+		Time:     time.Now(),
+		Cleared:  online,
+	})
+}
+
+// alert publishes an alert on <topic>/alerts
+func (b *Bridge) alert(a Alert) {
+	a.DeviceID = b.info.DeviceID
+	payload, err := json.Marshal(a)
+	if err != nil {
+		b.logger.Error("failed to encode alert", "err", err)
+		return
+	}
+	b.tr.Publish(b.topic+"/alerts", payload, false)
+	if b.opts.OnAlert != nil {
+		b.opts.OnAlert(a)
+	}
+}
@@ -0,0 +1,48 @@
+package bridge
+
+import (
+	"time"
+)
+
+// automate checks every automation and sends what they decide on to the
+// device. It's called once a minute and on every status update
+func (b *Bridge) automate(now time.Time) {
+	if len(b.opts.Automations) == 0 || b.recs != nil {
+		return
+	}
+	// Vacation mode keeps the device quiet, automations would undo that
+	if b.onVacation() {
+		return
+	}
+
+	// Status updates and the ticker in Run can get here at the same time,
+	// so make sure automations see one check after the other
+	b.autoMu.Lock()
+	defer b.autoMu.Unlock()
+
+	state := b.reported()
+	for _, a := range b.opts.Automations {
+		act := a.Check(now, state)
+		if act == nil {
+			continue
+		}
+		if act.Alert != "" {
+			b.alert(Alert{
+				Event:    EventAutomation,
+				Message:  act.Alert,
+				Severity: SeverityInfo,
+				Time:     now,
+				Cleared:  act.Cleared,
+			})
+		}
+		if act.Set == nil {
+			continue
+		}
+		if err := b.set(act.Set); err != nil {
+			b.logger.Warn("failed to apply automation", "automation", a.Name(), "err", err)
+			continue
+		}
+		b.logger.Info("applied automation", "automation", a.Name())
+		b.confirm(act.Set)
+	}
+}
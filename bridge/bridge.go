@@ -0,0 +1,883 @@
+// Package bridge observes a device and publishes its state as a Hemtjänst
+// device over MQTT.
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"hemtjan.st/klimat/aqi"
+	"hemtjan.st/klimat/automation"
+	"hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/logging"
+	"hemtjan.st/klimat/metrics"
+	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/device"
+	"lib.hemtjan.st/feature"
+)
+
+// Options configure a Bridge
+type Options struct {
+	// DeviceOptions are used when the bridge has to reconnect to the device.
+	// Every KeepAlive the bridge also checks that the device still
+	// responds. When it doesn't the device is marked offline, and we keep
+	// reconnecting until it's back
+	DeviceOptions philips.Options
+	// Rediscover is how often to look for the device on the network, to
+	// follow it when its address changes. Zero disables it
+	Rediscover time.Duration
+	// DiscoveryAddress is the multicast address used for Rediscover
+	DiscoveryAddress string
+	// RegistryPath is the registry to update when the device moved, if set
+	RegistryPath string
+	// Allowed reports if a device may be bridged, by its DeviceID. Devices
+	// it returns false for are refused with config.ErrNotAllowed
+	Allowed func(id string) bool
+	// FullRefresh is how often every feature is published, even if its
+	// value didn't change. In between only changed values are published.
+	// Zero publishes every value on every update
+	FullRefresh time.Duration
+	// Topic is the template for the MQTT topic of the device. {id}, {alias},
+	// {name} and {model} are replaced with the DeviceID, Alias, name and
+	// model of the device. It defaults to DefaultTopic
+	Topic string
+	// Alias is the name the device is known by in the configuration file.
+	// It's used for {alias} in Topic and falls back to the DeviceID
+	Alias string
+	// Refresh is how often to request the status of the device, on top of
+	// observing it. Every refresh publishes all features. Zero disables it
+	Refresh time.Duration
+	// ObserveTimeout is how long the device gets to send its first
+	// notification after it accepted an observation. Some firmware accepts
+	// observations but never notifies, and if that happens the bridge
+	// polls the status every PollInterval instead. Zero disables it
+	ObserveTimeout time.Duration
+	// PollInterval is how often the status is requested once observing
+	// didn't work out, defaults to 15 seconds
+	PollInterval time.Duration
+	// StaleAfter is how long the device can go without sending a
+	// notification before it's marked offline on the availability topic.
+	// Zero disables it
+	StaleAfter time.Duration
+	// LastWillID is the ClientID of the MQTT connection. Hemtjänst uses it
+	// to mark the device as gone when the will of the connection fires
+	LastWillID string
+	// NoRetain lists the features whose values are published without the
+	// retain flag. All other features are retained
+	NoRetain []string
+	// HomeAssistant is the Home Assistant discovery prefix to publish
+	// discovery configs under, usually "homeassistant". Empty disables it
+	HomeAssistant string
+	// Homie is the base topic to also publish the device on following the
+	// Homie convention, usually "homie". Empty disables it
+	Homie string
+	// Name, Manufacturer, Model and Type override what's announced for the
+	// device. By default the name and model the device reports are used,
+	// with Philips as manufacturer and airPurifier as type. Type only
+	// applies to the main device
+	Name         string
+	Manufacturer string
+	Model        string
+	Type         string
+	// AirQuality maps the reported state onto the airQuality feature. The
+	// zero value uses the indoor allergen index, like AirQualityIAI
+	AirQuality AirQualityMapping
+	// AQI is the air quality index published on the aqi feature, computed
+	// from PM2.5. The zero value disables the feature
+	AQI aqi.Index
+	// SmoothingAlpha smooths the PM2.5, humidity and temperature readings
+	// with an exponential moving average before they're published. Lower
+	// values smooth more, zero disables it
+	SmoothingAlpha float64
+	// SmoothingWindow smooths the same readings with a moving average over
+	// this many readings instead. Zero disables it
+	SmoothingWindow int
+	// PM25Max caps pm2_5Density, zero publishes the value as reported.
+	// pm2_5DensityLimited is always capped at 100
+	PM25Max int
+	// Features are the features to announce. If empty they're picked based
+	// on the model of the device, or all of them for unknown models
+	Features []string
+	// Split publishes the humidifier part of the device as a separate
+	// humidifierDehumidifier device on <topic>/humidifier, instead of
+	// having all features on the airPurifier device
+	Split bool
+	// Light also publishes the light ring as a dimmable lightbulb device on
+	// <topic>/light. Brightness stays available on the purifier too
+	Light bool
+	// AirQualitySensor also publishes the air quality and PM2.5 readings as
+	// a separate airQualitySensor device, on <topic>/airQualitySensor
+	AirQualitySensor bool
+	// TemperatureSensor also publishes the temperature as a separate
+	// temperatureSensor device, on <topic>/temperatureSensor
+	TemperatureSensor bool
+	// HumiditySensor also publishes the relative humidity as a separate
+	// humiditySensor device, on <topic>/humiditySensor
+	HumiditySensor bool
+	// StateDir is where the last status of the device is kept. On startup
+	// it's published, flagged as cached, until the device reports. Empty
+	// disables it
+	StateDir string
+	// ClearOnExit removes the retained values of all features when Run
+	// returns, instead of leaving the last state behind
+	ClearOnExit bool
+	// Metrics collects sensor readings and counters for the device, if set
+	Metrics *metrics.Metrics
+	// Failures keeps the notifications that couldn't be decoded, if set
+	Failures *Failures
+	// OnStatus is called with every status update after it's been
+	// published. It must not block
+	OnStatus func(deviceID string, state *philips.Reported)
+	// OnRaw is called with the decrypted status JSON of every
+	// notification, like it's published on <topic>/raw
+	OnRaw func(plain []byte)
+	// OnAlert is called for every alert, after it has been published. It
+	// must not block
+	OnAlert func(Alert)
+	// FanRamp is how long to wait between the fan speeds in between when a
+	// command changes the speed by more than one step, to avoid a sudden
+	// jump in noise. Zero changes it right away
+	FanRamp time.Duration
+	// Scenes are named bundles of settings, which are applied when their
+	// name is published to <topic>/scene/set
+	Scenes map[string]philips.Settings
+	// PresenceTopic ends vacation mode when anything is published to it.
+	// Empty means vacation mode only ends when it's switched off
+	PresenceTopic string
+	// Automations change the settings of the device on their own. They're
+	// checked once a minute and on every status update
+	Automations []automation.Automation
+	// Debug logs the payload and decoded state of every status update,
+	// whatever the log level is
+	Debug bool
+}
+
+// DefaultTopic is the default template for Options.Topic
+const DefaultTopic = "climate/{id}"
+
+// Bridge keeps a Hemtjänst device in sync with a physical device
+type Bridge struct {
+	opts   Options
+	info   *philips.Info
+	devs   []*hemDevice
+	tr     device.Transport
+	topic  string
+	logger *slog.Logger
+
+	// autoMu serializes checking the automations
+	autoMu sync.Mutex
+	// setMu serializes commands, so they don't interleave with a fan ramp
+	setMu sync.Mutex
+
+	mu    sync.Mutex
+	cl    *philips.Device
+	state *philips.Reported
+	recs  []philips.Recording
+
+	// last is the last value published for every feature
+	last     map[string]string
+	full     bool
+	lastFull time.Time
+
+	noRetain  map[string]bool
+	supported map[string]bool
+	scenes    map[string]*philips.Desired
+	smoothers map[string]smoother
+
+	// lastErr is the error code of the last update, if errSeen is set
+	lastErr   philips.ErrorCode
+	filterDue bool
+	errSeen   bool
+
+	diagnostics diagnostics
+	firmware    firmware
+
+	// seen is when the last notification was received
+	seen time.Time
+	// notified is when the last notification of an observation was
+	// received, unlike seen it's not touched by polling
+	notified  time.Time
+	available string
+	// cached is set while the state was restored from StateDir
+	cached bool
+	// vacation is set during vacation mode, vacationSaved are the settings
+	// to put back afterwards
+	vacation      bool
+	vacationSaved philips.Settings
+}
+
+// NewBridge returns a Bridge for a device, publishing to transport
+func NewBridge(dev *philips.Device, transport device.Transport, opts Options) (*Bridge, error) {
+	info, err := dev.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newBridge(info, transport, opts)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.cl = dev
+	b.mu.Unlock()
+	return b, nil
+}
+
+// NewReplayBridge returns a Bridge that publishes the notifications from a
+// recording, instead of observing a device
+func NewReplayBridge(recs []philips.Recording, transport device.Transport, opts Options) (*Bridge, error) {
+	info := philips.RecordingInfo(recs)
+	if info == nil {
+		return nil, fmt.Errorf("recording does not contain any device info")
+	}
+
+	b, err := newBridge(info, transport, opts)
+	if err != nil {
+		return nil, err
+	}
+	b.recs = recs
+	return b, nil
+}
+
+func newBridge(info *philips.Info, transport device.Transport, opts Options) (*Bridge, error) {
+	if opts.Allowed != nil && !opts.Allowed(info.DeviceID) {
+		return nil, fmt.Errorf("%w: %s", config.ErrNotAllowed, info.DeviceID)
+	}
+	if opts.DiscoveryAddress == "" {
+		opts.DiscoveryAddress = philips.DiscoveryAddress
+	}
+
+	if opts.Topic == "" {
+		opts.Topic = DefaultTopic
+	}
+
+	topic := ExpandTopic(opts.Topic, opts.Alias, info)
+	b := &Bridge{
+		opts:  opts,
+		info:  info,
+		tr:    transport,
+		topic: topic,
+		last:  map[string]string{},
+
+		noRetain:    map[string]bool{},
+		diagnostics: diagnostics{started: time.Now()},
+		logger:      slog.With("subsystem", "bridge", "device", info.DeviceID),
+	}
+	if opts.Debug {
+		b.logger = logging.Verbose(b.logger)
+	}
+	for _, name := range opts.NoRetain {
+		if _, ok := features()[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		b.noRetain[name] = true
+	}
+
+	name, typ := b.name(), "airPurifier"
+	if opts.Type != "" {
+		typ = opts.Type
+	}
+
+	supported, err := supportedFeatures(info.ModelID, opts.Features)
+	if err != nil {
+		return nil, err
+	}
+	b.supported = supported
+
+	b.scenes, err = parseScenes(opts.Scenes)
+	if err != nil {
+		return nil, err
+	}
+
+	b.smoothers, err = newSmoothers(opts.SmoothingAlpha, opts.SmoothingWindow)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.AQI.Breakpoints) == 0 {
+		delete(b.supported, "aqi")
+	}
+
+	// There's nothing to split off if the model can't humidify
+	if opts.Split && b.supports("targetHumidifierDehumidifierState") {
+		if err := b.addDevice(topic, name, typ, without(humidifierFeatures...), nil); err != nil {
+			return nil, err
+		}
+		err = b.addDevice(topic+"/humidifier", name+" Humidifier", "humidifierDehumidifier",
+			only(append([]string{"on"}, humidifierFeatures...)...), nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := b.addDevice(topic, name, typ, features(), nil); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Light {
+		err := b.addDevice(topic+"/light", name+" Light", "lightbulb",
+			map[string]*feature.Info{"on": {}, "brightness": {}}, map[string]string{"lightOn": "on"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.AirQualitySensor {
+		err := b.addDevice(topic+"/airQualitySensor", name+" Air Quality", "airQualitySensor",
+			only("airQuality", "pm2_5Density"), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.TemperatureSensor {
+		err := b.addDevice(topic+"/temperatureSensor", name+" Temperature", "temperatureSensor",
+			only("currentTemperature"), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.HumiditySensor {
+		err := b.addDevice(topic+"/humiditySensor", name+" Humidity", "humiditySensor",
+			only("currentRelativeHumidity"), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.handleSets()
+	go b.handleGet()
+	if len(b.scenes) > 0 {
+		go b.handleScenes()
+	}
+	go b.handleVacation()
+	if opts.PresenceTopic != "" {
+		go b.handlePresence()
+	}
+	if opts.HomeAssistant != "" {
+		go b.handleHomeAssistant(opts.HomeAssistant)
+	}
+	if opts.Homie != "" {
+		b.handleHomie()
+	}
+	return b, nil
+}
+
+// ExpandTopic fills in the placeholders of a topic template, see
+// Options.Topic
+func ExpandTopic(tmpl, alias string, info *philips.Info) string {
+	if alias == "" {
+		alias = info.DeviceID
+	}
+	return strings.NewReplacer(
+		"{id}", info.DeviceID,
+		"{alias}", alias,
+		"{name}", info.Name,
+		"{model}", info.ModelID,
+	).Replace(tmpl)
+}
+
+// Topic returns the MQTT topic the device is published on
+func (b *Bridge) Topic() string {
+	return b.topic
+}
+
+// Info returns the info of the device being bridged
+func (b *Bridge) Info() *philips.Info {
+	return b.info
+}
+
+// Alias returns the alias of the device in the configuration file, if it
+// has one
+func (b *Bridge) Alias() string {
+	return b.opts.Alias
+}
+
+// Status returns a copy of the last state the device reported, or nil if it
+// hasn't reported anything yet
+func (b *Bridge) Status() *philips.Reported {
+	state := b.reported()
+	if state == nil {
+		return nil
+	}
+	res := *state
+	return &res
+}
+
+// Online reports if the device was last published as online
+func (b *Bridge) Online() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.available == "online"
+}
+
+// Run observes the device and publishes every update until the context is
+// cancelled. When replaying a recording it returns once the recording has
+// been published in full
+func (b *Bridge) Run(ctx context.Context) error {
+	if b.recs != nil {
+		return philips.Replay(ctx, b.recs, true, b.handleStatus)
+	}
+
+	if b.opts.StateDir != "" {
+		b.restoreState()
+	}
+
+	// lost is set while the device isn't responding
+	lost := false
+
+	b.logger.Info("starting observer for status messages")
+	obs, err := b.cl.Status(b.handleObserve)
+	if err != nil {
+		if b.opts.DeviceOptions.KeepAlive <= 0 {
+			return err
+		}
+		// Leave it to the keepalive to reconnect
+		b.logger.Warn("failed to observe device, reconnecting", "err", err)
+		lost = true
+	}
+
+	var rediscover <-chan time.Time
+	if b.opts.Rediscover > 0 {
+		t := time.NewTicker(b.opts.Rediscover)
+		defer t.Stop()
+		rediscover = t.C
+	}
+
+	b.mu.Lock()
+	b.seen = time.Now()
+	b.mu.Unlock()
+
+	var refresh <-chan time.Time
+	if b.opts.Refresh > 0 {
+		t := time.NewTicker(b.opts.Refresh)
+		defer t.Stop()
+		refresh = t.C
+	}
+
+	var keepalive <-chan time.Time
+	if b.opts.DeviceOptions.KeepAlive > 0 {
+		t := time.NewTicker(b.opts.DeviceOptions.KeepAlive)
+		defer t.Stop()
+		keepalive = t.C
+	}
+	// recovering counts the attempts to get a stale observation going
+	// again. The first one observes again, the ones after reconnect
+	recovering := 0
+
+	var stale <-chan time.Time
+	if b.opts.StaleAfter > 0 {
+		t := time.NewTicker(b.opts.StaleAfter / 2)
+		defer t.Stop()
+		stale = t.C
+	}
+
+	var automate <-chan time.Time
+	if len(b.opts.Automations) > 0 {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		automate = t.C
+	}
+
+	// observeCheck fires ObserveTimeout after observing started, poll
+	// once observing turned out not to deliver anything
+	var (
+		observeCheck <-chan time.Time
+		observed     time.Time
+		poll         <-chan time.Time
+	)
+	checkObserve := func() {
+		if b.opts.ObserveTimeout > 0 && poll == nil {
+			observed = time.Now()
+			observeCheck = time.After(b.opts.ObserveTimeout)
+		}
+	}
+	if obs != nil {
+		checkObserve()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if obs != nil {
+				obs.Cancel()
+			}
+			b.cl.Close()
+			b.shutdown()
+			return nil
+		case now := <-automate:
+			b.automate(now)
+		case <-observeCheck:
+			b.mu.Lock()
+			notified := b.notified
+			b.mu.Unlock()
+			if !notified.Before(observed) || lost {
+				continue
+			}
+			interval := b.opts.PollInterval
+			if interval <= 0 {
+				interval = 15 * time.Second
+			}
+			b.logger.Warn("device accepted the observation but sent no notifications, polling its status instead",
+				"waited", b.opts.ObserveTimeout, "interval", interval)
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			poll = t.C
+			b.pollStatus(false)
+		case <-poll:
+			b.pollStatus(false)
+		case <-refresh:
+			b.pollStatus(true)
+		case <-keepalive:
+			if !lost {
+				_, err := b.cl.Info()
+				if err == nil {
+					continue
+				}
+				lost = true
+				if prev := b.setAvailable(false); prev == "online" {
+					b.availabilityAlert(false)
+				}
+				b.logger.Warn("device stopped responding, reconnecting", "err", err)
+			}
+
+			address := b.cl.Address()
+			if b.opts.Rediscover > 0 {
+				if found, ok := b.locate(ctx); ok {
+					address = found
+				}
+			}
+			nobs, err := b.reconnect(ctx, address, obs)
+			if err != nil {
+				b.logger.Warn("failed to reconnect", "address", address, "retry", b.opts.DeviceOptions.KeepAlive, "err", err)
+				continue
+			}
+			obs, lost = nobs, false
+			checkObserve()
+			b.logger.Info("reconnected to device", "address", address)
+		case <-stale:
+			b.mu.Lock()
+			seen := b.seen
+			b.mu.Unlock()
+			if time.Since(seen) <= b.opts.StaleAfter {
+				if recovering > 0 {
+					b.logger.Info("observation recovered")
+					b.diagRecovery()
+				}
+				recovering = 0
+				continue
+			}
+			if prev := b.setAvailable(false); prev != "offline" {
+				if prev == "online" {
+					b.availabilityAlert(false)
+				}
+				b.logger.Warn("no status received, marking device offline", "seen", seen)
+			}
+			if lost {
+				// The keepalive is already reconnecting
+				continue
+			}
+
+			// The connection might be fine and only the observation
+			// died, so try observing again before reconnecting
+			recovering++
+			if recovering == 1 {
+				nobs, err := b.cl.Status(b.handleObserve)
+				if err == nil {
+					b.logger.Info("observing device again")
+					obs.Cancel()
+					obs = nobs
+					checkObserve()
+					b.diagReobserve()
+					continue
+				}
+				b.logger.Warn("failed to observe device again", "err", err)
+			}
+			nobs, err := b.reconnect(ctx, b.cl.Address(), obs)
+			if err != nil {
+				b.logger.Warn("failed to reconnect", "address", b.cl.Address(), "err", err)
+				continue
+			}
+			obs = nobs
+			checkObserve()
+			b.logger.Info("reconnected to device", "address", b.cl.Address())
+		case <-rediscover:
+			address, ok := b.locate(ctx)
+			if !ok || philips.SameAddress(address, b.cl.Address()) {
+				continue
+			}
+
+			b.logger.Info("device moved, reconnecting", "from", b.cl.Address(), "to", address)
+			nobs, err := b.reconnect(ctx, address, obs)
+			if err != nil {
+				b.logger.Warn("failed to reconnect, staying on the old address", "address", b.cl.Address(), "err", err)
+				continue
+			}
+			obs, lost = nobs, false
+			checkObserve()
+		}
+	}
+}
+
+// reconnect connects to the device on address, syncs a new session and
+// observes it. If that worked the current connection and observation obs,
+// which may be nil, are replaced, and the new observation is returned
+func (b *Bridge) reconnect(ctx context.Context, address string, obs *coap.Observation) (*coap.Observation, error) {
+	cl, err := philips.NewWithOptions(ctx, address, b.opts.DeviceOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	nobs, err := cl.Status(b.handleObserve)
+	if err != nil {
+		cl.Close()
+		return nil, fmt.Errorf("failed to observe %s: %w", address, err)
+	}
+	if obs != nil {
+		obs.Cancel()
+	}
+	b.cl.Close()
+	b.mu.Lock()
+	b.cl = cl
+	b.mu.Unlock()
+	b.diagReconnect()
+	return nobs, nil
+}
+
+// shutdown announces the device is no longer available, and clears its
+// retained values if configured to
+func (b *Bridge) shutdown() {
+	b.setAvailable(false)
+	b.homieState("disconnected")
+	if b.opts.ClearOnExit {
+		b.clearRetained()
+	}
+}
+
+// locate runs discovery and returns the address the device responded from.
+// The registry is updated with the new address so other commands find it too
+func (b *Bridge) locate(ctx context.Context) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		address string
+	)
+	err := philips.Discover(ctx, b.opts.DiscoveryAddress, func(addr string, found philips.Info) {
+		mu.Lock()
+		defer mu.Unlock()
+		if found.DeviceID != b.info.DeviceID {
+			return
+		}
+		if address == "" || philips.BetterAddress(addr, address) {
+			address = addr
+		}
+	})
+	if err != nil {
+		b.logger.Warn("failed to rediscover device", "err", err)
+		return "", false
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if address == "" {
+		return "", false
+	}
+
+	if b.opts.RegistryPath != "" {
+		reg, err := config.LoadRegistry(b.opts.RegistryPath)
+		if err == nil {
+			reg.Seen(b.info.DeviceID, address, b.info.Name, b.info.ModelID, time.Now())
+			err = reg.Save(b.opts.RegistryPath)
+		}
+		if err != nil {
+			b.logger.Warn("failed to update registry", "err", err)
+		}
+	}
+	return address, true
+}
+
+// pollStatus requests the status once and handles it like a notification.
+// With full every feature is published, even the ones that didn't change
+func (b *Bridge) pollStatus(full bool) {
+	payload, err := b.cl.GetStatus()
+	if err != nil {
+		b.logger.Warn("failed to request status", "err", err)
+		return
+	}
+	if full {
+		b.mu.Lock()
+		b.lastFull = time.Time{}
+		b.mu.Unlock()
+	}
+	b.handleStatus(payload)
+}
+
+func (b *Bridge) handleObserve(req *coap.Request) {
+	b.mu.Lock()
+	b.notified = time.Now()
+	b.mu.Unlock()
+
+	// If the message was confirmable, confirm it before
+	// proceeding with decoding it. This ensures that even
+	// if we hit decoding issues, we always confirm the
+	// message so the device continues sending new messages
+	if err := philips.Acknowledge(req); err != nil {
+		b.logger.Warn("failed to acknowledge message", "err", err)
+	}
+	b.handleStatus(req.Msg.Payload())
+}
+
+func (b *Bridge) handleStatus(payload []byte) {
+	plain, err := philips.DecodeMessage(payload)
+	if err != nil {
+		b.logger.Warn("failed to decode status", "err", err, "payload", string(payload))
+		b.publishRaw(nil, err, payload)
+		b.diagDecodeError(err)
+		b.recordFailure(err, payload)
+		return
+	}
+	data, err := philips.ParseStatus(plain)
+	if err != nil {
+		b.logger.Warn("failed to parse status", "err", err, "payload", string(plain))
+		b.publishRaw(nil, err, plain)
+		b.diagDecodeError(err)
+		b.recordFailure(err, plain)
+		return
+	}
+	b.publishRaw(plain, nil, nil)
+	if b.opts.OnRaw != nil {
+		b.opts.OnRaw(plain)
+	}
+	b.logger.Debug("received status", "payload", string(plain))
+	b.logger.Debug("decoded status", "state", fmt.Sprintf("%+v", data.State.Reported))
+
+	now := time.Now()
+	b.mu.Lock()
+	var interval time.Duration
+	if !b.seen.IsZero() {
+		interval = now.Sub(b.seen)
+	}
+	state := b.smooth(data.State.Reported)
+	b.state = state
+	b.seen = now
+	cached := b.cached
+	b.cached = false
+	b.mu.Unlock()
+
+	if cached {
+		b.tr.Publish(b.topic+"/cached", []byte("false"), true)
+	}
+	if b.opts.StateDir != "" && b.recs == nil {
+		if err := b.saveState(plain, now); err != nil {
+			b.logger.Warn("failed to save state", "err", err)
+		}
+	}
+
+	b.tr.Publish(b.topic+"/lastSeen", []byte(now.UTC().Format(time.RFC3339)), true)
+
+	if prev := b.setAvailable(true); prev == "offline" {
+		b.availabilityAlert(true)
+	}
+	b.update(state)
+	b.checkAlerts(state)
+	b.checkFirmware(state)
+	b.updateMetrics(state)
+	if b.opts.OnStatus != nil {
+		b.opts.OnStatus(b.info.DeviceID, state)
+	}
+	// Automations send commands, which shouldn't hold up the observation
+	go b.automate(now)
+	b.diagStatus(data.State.Reported.Runtime, interval)
+}
+
+// Refresh publishes the last reported state and availability of the device
+// again in full. Call it after the MQTT connection was lost, since updates
+// published while it was down may not have made it to the broker
+func (b *Bridge) Refresh() {
+	b.mu.Lock()
+	state := b.state
+	available := b.available
+	b.available = ""
+	b.lastFull = time.Time{}
+	b.mu.Unlock()
+
+	if available != "" {
+		b.setAvailable(available == "online")
+	}
+	if state != nil {
+		b.update(state)
+	}
+}
+
+// handleGet publishes the last reported state in full whenever anything is
+// published to <topic>/get. The device only sends a notification when
+// something changed, which can take minutes, so this lets clients get the
+// current state without having to wait for one
+func (b *Bridge) handleGet() {
+	for range b.tr.Subscribe(b.topic + "/get") {
+		b.mu.Lock()
+		state := b.state
+		b.lastFull = time.Time{}
+		b.mu.Unlock()
+
+		if state == nil {
+			continue
+		}
+		b.update(state)
+	}
+}
+
+// setAvailable publishes whether the device is online to
+// <topic>/availability, and returns what it was before. That's empty if
+// nothing was published yet
+func (b *Bridge) setAvailable(online bool) string {
+	value := "offline"
+	if online {
+		value = "online"
+	}
+
+	b.mu.Lock()
+	prev := b.available
+	b.available = value
+	b.mu.Unlock()
+
+	if prev != value {
+		b.tr.Publish(b.topic+"/availability", []byte(value), true)
+		if online {
+			b.homieState("ready")
+		} else {
+			b.homieState("lost")
+		}
+	}
+	return prev
+}
+
+// rawError is published on the raw topic when a notification couldn't be
+// decoded
+type rawError struct {
+	Error   string `json:"error"`
+	Payload string `json:"payload"`
+}
+
+// publishRaw publishes the decrypted status JSON to <topic>/raw, or the
+// error and offending payload if it couldn't be decoded or parsed
+func (b *Bridge) publishRaw(plain []byte, err error, payload []byte) {
+	if err != nil {
+		msg, jerr := json.Marshal(rawError{Error: err.Error(), Payload: string(payload)})
+		if jerr != nil {
+			return
+		}
+		plain = msg
+	}
+	b.tr.Publish(b.topic+"/raw", plain, false)
+}
+
+// reported returns the last state the device reported, or nil if it hasn't
+// reported anything yet
+func (b *Bridge) reported() *philips.Reported {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"fmt"
+
+	"lib.hemtjan.st/client"
+	"lib.hemtjan.st/device"
+	"lib.hemtjan.st/feature"
+)
+
+// hemDevice is one of the Hemtjänst devices a physical device is published
+// as. Besides the main device, sensors can be published as devices of
+// their own so they show up separately
+type hemDevice struct {
+	client.Device
+	topic string
+	// names maps the name the bridge uses for a feature to the name of the
+	// feature on this device. They're usually the same, but the light ring
+	// is turned on and off through "on" on the lightbulb device, which has
+	// nothing to do with "on" on the purifier
+	names map[string]string
+}
+
+// has reports if the device has a feature
+func (d *hemDevice) has(name string) bool {
+	_, ok := d.names[name]
+	return ok
+}
+
+// feature returns a feature of the device by the name the bridge uses
+func (d *hemDevice) feature(name string) client.Feature {
+	return d.Feature(d.names[name])
+}
+
+// getTopic returns the topic the values of a feature are published on
+func (d *hemDevice) getTopic(name string) string {
+	name = d.names[name]
+	if ft, ok := d.Info().Features[name]; ok && ft.GetTopic != "" {
+		return ft.GetTopic
+	}
+	return d.topic + "/" + name + "/get"
+}
+
+// setTopic returns the topic set requests for a feature are received on
+func (d *hemDevice) setTopic(name string) string {
+	name = d.names[name]
+	if ft, ok := d.Info().Features[name]; ok && ft.SetTopic != "" {
+		return ft.SetTopic
+	}
+	return d.topic + "/" + name + "/set"
+}
+
+// humidifierFeatures are the features that move to the humidifier device
+// when Options.Split is set
+var humidifierFeatures = []string{
+	"currentRelativeHumidity",
+	"targetRelativeHumidity",
+	"currentHumidifierDehumidifierState",
+	"targetHumidifierDehumidifierState",
+	"waterLevel",
+	"tankEmpty",
+	"tankOpen",
+}
+
+// without returns features() without the given names
+func without(names ...string) map[string]*feature.Info {
+	fts := features()
+	for _, n := range names {
+		delete(fts, n)
+	}
+	return fts
+}
+
+// only returns the subset of features() with the given names
+func only(names ...string) map[string]*feature.Info {
+	all := features()
+	fts := make(map[string]*feature.Info, len(names))
+	for _, n := range names {
+		fts[n] = all[n]
+	}
+	return fts
+}
+
+// addDevice creates a Hemtjänst device with the given features on topic,
+// and adds it to the devices the bridge publishes to. rename maps the
+// names the bridge uses for features onto the names of the features on the
+// device, for the ones that differ
+func (b *Bridge) addDevice(topic, name, typ string, fts map[string]*feature.Info, rename map[string]string) error {
+	names := make(map[string]string, len(fts))
+	for n := range fts {
+		names[n] = n
+	}
+	for from, to := range rename {
+		delete(names, to)
+		names[from] = to
+	}
+	for from, to := range names {
+		if !b.supports(from) {
+			delete(names, from)
+			delete(fts, to)
+		}
+	}
+	if len(fts) == 0 {
+		// Nothing this model supports would end up on the device
+		return nil
+	}
+
+	dev, err := client.NewDevice(&device.Info{
+		Topic:        topic,
+		Name:         name,
+		Manufacturer: b.manufacturer(),
+		Model:        b.model(),
+		SerialNumber: b.info.DeviceID,
+		Type:         typ,
+		LastWillID:   b.opts.LastWillID,
+		Features:     fts,
+	}, b.tr)
+	if err != nil {
+		return fmt.Errorf("failed to create %s device: %w", typ, err)
+	}
+	b.devs = append(b.devs, &hemDevice{Device: dev, topic: topic, names: names})
+	return nil
+}
+
+// name returns the name to announce the device with
+func (b *Bridge) name() string {
+	if b.opts.Name != "" {
+		return b.opts.Name
+	}
+	return b.info.Name
+}
+
+// manufacturer returns the manufacturer to announce the device with
+func (b *Bridge) manufacturer() string {
+	if b.opts.Manufacturer != "" {
+		return b.opts.Manufacturer
+	}
+	return "Philips"
+}
+
+// model returns the model to announce the device with
+func (b *Bridge) model() string {
+	if b.opts.Model != "" {
+		return b.opts.Model
+	}
+	return b.info.ModelID
+}
+
+// deviceFor returns the first device that has a feature, preferring the
+// main device
+func (b *Bridge) deviceFor(name string) *hemDevice {
+	for _, d := range b.devs {
+		if d.has(name) {
+			return d
+		}
+	}
+	return b.devs[0]
+}
+
+// getTopic returns the topic the values of a feature are published on
+func (b *Bridge) getTopic(name string) string {
+	return b.deviceFor(name).getTopic(name)
+}
+
+// setTopic returns the topic set requests for a feature are received on
+func (b *Bridge) setTopic(name string) string {
+	return b.deviceFor(name).setTopic(name)
+}
@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"strconv"
+	"time"
+)
+
+// diagnostics keeps track of how the bridge is doing. The values are
+// published retained under <topic>/diagnostics
+type diagnostics struct {
+	started    time.Time
+	reconnects int
+	reobserves int
+	recoveries int
+}
+
+// diag publishes a diagnostics value
+func (b *Bridge) diag(name, value string) {
+	b.tr.Publish(b.topic+"/diagnostics/"+name, []byte(value), true)
+}
+
+// diagStatus publishes the diagnostics that change with every notification.
+// runtime is the device's uptime in milliseconds, as it reports it, and
+// interval is the time since the notification before it, if there was one
+func (b *Bridge) diagStatus(runtime int, interval time.Duration) {
+	b.mu.Lock()
+	started := b.diagnostics.started
+	b.mu.Unlock()
+
+	b.diag("runtimeSeconds", strconv.Itoa(runtime/1000))
+	b.diag("uptime", strconv.Itoa(int(time.Since(started).Seconds())))
+	if interval > 0 {
+		b.diag("interval", strconv.Itoa(int(interval.Seconds())))
+	}
+}
+
+// diagReconnect counts a reconnect to the device
+func (b *Bridge) diagReconnect() {
+	b.mu.Lock()
+	b.diagnostics.reconnects++
+	n := b.diagnostics.reconnects
+	b.mu.Unlock()
+
+	b.diag("reconnects", strconv.Itoa(n))
+	b.count("klimat_reconnects_total")
+}
+
+// diagReobserve counts observing the device again after the observation
+// went stale
+func (b *Bridge) diagReobserve() {
+	b.mu.Lock()
+	b.diagnostics.reobserves++
+	n := b.diagnostics.reobserves
+	b.mu.Unlock()
+
+	b.diag("reobserves", strconv.Itoa(n))
+}
+
+// diagRecovery counts a stale observation that got going again
+func (b *Bridge) diagRecovery() {
+	b.mu.Lock()
+	b.diagnostics.recoveries++
+	n := b.diagnostics.recoveries
+	b.mu.Unlock()
+
+	b.diag("recoveries", strconv.Itoa(n))
+}
+
+// diagDecodeError publishes the last notification that couldn't be decoded
+func (b *Bridge) diagDecodeError(err error) {
+	b.diag("lastDecodeError", time.Now().Format(time.RFC3339)+" "+err.Error())
+	b.count("klimat_decode_errors_total")
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DecodeFailure is a notification that couldn't be decoded or parsed
+type DecodeFailure struct {
+	DeviceID string    `json:"device_id"`
+	Time     time.Time `json:"timestamp"`
+	Error    string    `json:"error"`
+	// Payload is what we failed on. That's the payload as received if it
+	// couldn't be decoded, and the decrypted JSON if it couldn't be parsed
+	Payload string `json:"payload"`
+}
+
+// Failures keeps the last decode failures of one or more bridges, so they
+// can be attached to a bug report
+type Failures struct {
+	mu    sync.Mutex
+	items []DecodeFailure
+	next  int
+	full  bool
+}
+
+// NewFailures returns a Failures that keeps the last size failures
+func NewFailures(size int) *Failures {
+	if size < 0 {
+		size = 0
+	}
+	return &Failures{items: make([]DecodeFailure, size)}
+}
+
+// Add adds a failure, and drops the oldest one if there's no room left
+func (f *Failures) Add(d DecodeFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.items) == 0 {
+		return
+	}
+	f.items[f.next] = d
+	f.next = (f.next + 1) % len(f.items)
+	if f.next == 0 {
+		f.full = true
+	}
+}
+
+// List returns the failures, oldest first
+func (f *Failures) List() []DecodeFailure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.full {
+		return append([]DecodeFailure{}, f.items[:f.next]...)
+	}
+	return append(append([]DecodeFailure{}, f.items[f.next:]...), f.items[:f.next]...)
+}
+
+// ServeHTTP serves the failures as a JSON array
+func (f *Failures) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.List())
+}
+
+// recordFailure keeps a notification that couldn't be decoded, if we're
+// keeping them
+func (b *Bridge) recordFailure(err error, payload []byte) {
+	if b.opts.Failures == nil {
+		return
+	}
+	b.opts.Failures.Add(DecodeFailure{
+		DeviceID: b.info.DeviceID,
+		Time:     time.Now(),
+		Error:    err.Error(),
+		Payload:  string(payload),
+	})
+}
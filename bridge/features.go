@@ -0,0 +1,275 @@
+package bridge
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/feature"
+)
+
+const (
+	twoWeeks = 336 // hours
+	// pm25Limit is the highest PM2.5 density published on
+	// pm2_5DensityLimited, the old cap of pm2_5Density
+	pm25Limit = 100
+)
+
+// filter is one of the filters of the device, with how long it lasts when
+// new. The lifetimes are the ones Philips documents for the AirCombi
+type filter struct {
+	name     string
+	lifetime int // hours
+	left     func(*philips.Reported) int
+}
+
+var filters = []filter{
+	{"prefilter", 360, func(r *philips.Reported) int { return r.PrefilterAndWickCleanIn }},
+	{"hepaFilter", 4800, func(r *philips.Reported) int { return r.HEPAFilterReplaceIn }},
+	{"carbonFilter", 2400, func(r *philips.Reported) int { return r.ActiveCarbonFilterReplaceIn }},
+	{"wick", 4800, func(r *philips.Reported) int { return r.WickReplaceIn }},
+}
+
+// lifeLevel returns how much of its life a filter has left, in percent
+func (f filter) lifeLevel(r *philips.Reported) int {
+	left := f.left(r)
+	if left <= 0 {
+		return 0
+	}
+	if left >= f.lifetime {
+		return 100
+	}
+	return left * 100 / f.lifetime
+}
+
+// features returns the features announced for a device
+func features() map[string]*feature.Info {
+	fts := map[string]*feature.Info{
+		"on":                                 {},
+		"brightness":                         {},
+		"currentAirPurifierState":            {},
+		"targetAirPurifierState":             {},
+		"currentFanState":                    {},
+		"targetFanState":                     {},
+		"rotationSpeed":                      {},
+		"lockPhysicalControls":               {},
+		"airQuality":                         {},
+		"pm2_5Density":                       {},
+		"pm2_5DensityLimited":                {Max: pm25Limit},
+		"aqi":                                {},
+		"filterChangeIndication":             {},
+		"currentRelativeHumidity":            {},
+		"targetRelativeHumidity":             {},
+		"currentHumidifierDehumidifierState": {},
+		"targetHumidifierDehumidifierState":  {},
+		"currentTemperature":                 {},
+		"waterLevel":                         {},
+		"tankEmpty":                          {},
+		"tankOpen":                           {},
+	}
+	// Every filter gets a LifeLevel (percent) and HoursRemaining feature
+	for _, f := range filters {
+		fts[f.name+"LifeLevel"] = &feature.Info{Min: 0, Max: 100}
+		fts[f.name+"HoursRemaining"] = &feature.Info{}
+	}
+	return fts
+}
+
+// supportedFeatures returns which features to announce for a model. If
+// override is set those features are announced as is, otherwise the
+// features the model doesn't support are left out. For unknown models
+// everything is announced
+func supportedFeatures(modelID string, override []string) (map[string]bool, error) {
+	all := features()
+	res := make(map[string]bool, len(all))
+	if len(override) > 0 {
+		for _, name := range override {
+			if _, ok := all[name]; !ok {
+				return nil, fmt.Errorf("unknown feature %q", name)
+			}
+			res[name] = true
+		}
+		return res, nil
+	}
+
+	for name := range all {
+		res[name] = true
+	}
+	m, ok := philips.LookupModel(modelID)
+	if !ok {
+		slog.Warn("unknown model, announcing all features. Use -features to pick the ones it supports", "model", modelID)
+		return res, nil
+	}
+	if !m.Humidifier {
+		delete(res, "targetRelativeHumidity")
+		delete(res, "currentHumidifierDehumidifierState")
+		delete(res, "targetHumidifierDehumidifierState")
+		delete(res, "waterLevel")
+		delete(res, "tankEmpty")
+		delete(res, "tankOpen")
+		delete(res, "wickLifeLevel")
+		delete(res, "wickHoursRemaining")
+	}
+	if !m.HumiditySensor {
+		delete(res, "currentRelativeHumidity")
+	}
+	if !m.TemperatureSensor {
+		delete(res, "currentTemperature")
+	}
+	return res, nil
+}
+
+// filterDue reports if any of the filters needs changing or cleaning
+func filterDue(update *philips.Reported) bool {
+	return update.ActiveCarbonFilterReplaceIn <= twoWeeks ||
+		update.HEPAFilterReplaceIn <= twoWeeks ||
+		update.WickReplaceIn <= twoWeeks ||
+		update.PrefilterAndWickCleanIn <= 0 ||
+		update.Err == philips.ErrCleanFilter
+}
+
+// boolFeature returns the value of a boolean feature
+func boolFeature(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// supports reports if a feature is announced. Names that aren't in
+// features(), like lightOn, are always supported
+func (b *Bridge) supports(name string) bool {
+	if _, ok := features()[name]; !ok {
+		return true
+	}
+	return b.supported[name]
+}
+
+// publish updates the value of a feature, unless it's the same value we
+// published last time and we're not doing a full refresh
+func (b *Bridge) publish(name, value string) {
+	b.mu.Lock()
+	last, ok := b.last[name]
+	if ok && last == value && !b.full {
+		b.mu.Unlock()
+		return
+	}
+	b.last[name] = value
+	b.mu.Unlock()
+
+	b.homiePublish(name, value)
+
+	for _, d := range b.devs {
+		if !d.has(name) {
+			continue
+		}
+		if b.noRetain[name] {
+			b.tr.Publish(d.getTopic(name), []byte(value), false)
+			continue
+		}
+		d.feature(name).Update(value)
+	}
+}
+
+// clearRetained removes the retained values of all features, so nothing
+// stale is left behind on the broker
+func (b *Bridge) clearRetained() {
+	b.mu.Lock()
+	b.last = map[string]string{}
+	b.mu.Unlock()
+
+	for _, d := range b.devs {
+		for name := range d.names {
+			b.tr.Publish(d.getTopic(name), []byte{}, true)
+		}
+	}
+	b.tr.Publish(b.topic+"/lastSeen", []byte{}, true)
+}
+
+// update maps the reported state of the device onto its features
+func (b *Bridge) update(update *philips.Reported) {
+	b.mu.Lock()
+	if now := time.Now(); now.Sub(b.lastFull) >= b.opts.FullRefresh {
+		b.full = true
+		b.lastFull = now
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.full = false
+		b.mu.Unlock()
+	}()
+
+	b.publish("on", update.Power.ToHemtjanst())
+	// Possible states are 0 (auto), 1 (humidifier) and 2 (dehumidifier), but
+	// since this device is only a humidifier we always use 1. Whether it's
+	// humidifying or only purifying shows in currentHumidifierDehumidifierState
+	b.publish("targetHumidifierDehumidifierState", "1")
+	if update.ChildLock {
+		b.publish("lockPhysicalControls", "1")
+	} else {
+		b.publish("lockPhysicalControls", "0")
+	}
+
+	// The tank is reported through the error code, which only ever holds one
+	// error. An open tank hides whether it's empty
+	b.publish("tankEmpty", boolFeature(update.Err == philips.ErrNoWater))
+	b.publish("tankOpen", boolFeature(update.Err == philips.ErrWaterTankOpen))
+
+	for _, f := range filters {
+		b.publish(f.name+"LifeLevel", strconv.Itoa(f.lifeLevel(update)))
+		b.publish(f.name+"HoursRemaining", strconv.Itoa(f.left(update)))
+	}
+
+	if update.Mode == philips.Manual {
+		b.publish("targetAirPurifierState", "0")
+		b.publish("targetFanState", "0")
+	} else {
+		b.publish("targetAirPurifierState", "1")
+		b.publish("targetFanState", "1")
+	}
+
+	if update.Power == philips.On {
+		// Only update certain values, like the sensors and operating aspects
+		// if the device is on
+		b.publish("brightness", update.Brightness.ToHemtjanst())
+		if update.Brightness > philips.Brightness0 {
+			b.publish("lightOn", "1")
+		} else {
+			b.publish("lightOn", "0")
+		}
+		b.publish("currentAirPurifierState", "2")
+		b.publish("currentFanState", "2")
+		b.publish("rotationSpeed", update.FanSpeed.ToHemtjanst())
+		b.publish("airQuality", b.opts.AirQuality.Map(update))
+		pm25 := update.ParticulateMatter25
+		if max := b.opts.PM25Max; max > 0 && pm25 > max {
+			pm25 = max
+		}
+		b.publish("pm2_5Density", strconv.Itoa(pm25))
+		b.publish("pm2_5DensityLimited", strconv.Itoa(int(math.Min(float64(update.ParticulateMatter25), pm25Limit))))
+		b.publish("aqi", strconv.Itoa(b.opts.AQI.PM25(float64(update.ParticulateMatter25))))
+		// HomeKit doesn't really have the concept of multiple filters, each of which
+		// could need changing, so flip this value if any of the filters need changing
+		// or cleaning
+		b.publish("filterChangeIndication", boolFeature(filterDue(update)))
+		b.publish("currentRelativeHumidity", strconv.Itoa(update.RelativeHumidity))
+		b.publish("targetRelativeHumidity", strconv.Itoa(update.RelativeHumidityTarget))
+		b.publish("currentHumidifierDehumidifierState", update.Function.ToHemtjanst())
+		b.publish("currentTemperature", strconv.Itoa(update.Temperature))
+		b.publish("waterLevel", strconv.Itoa(update.WaterLevel))
+	} else {
+		// Set certain values to 0 when we turn the device off so it looks like
+		// it's not doing anything
+		b.publish("brightness", "0")
+		b.publish("lightOn", "0")
+		b.publish("currentAirPurifierState", "0")
+		b.publish("currentFanState", "0")
+		b.publish("rotationSpeed", "0")
+		b.publish("currentHumidifierDehumidifierState", "0")
+	}
+}
@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"fmt"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// firmware is the firmware a device reported
+type firmware struct {
+	version string
+	wifi    string
+	ota     string
+}
+
+// checkFirmware publishes the firmware versions retained under
+// <topic>/firmware when they change, and raises an alert when the firmware
+// was updated while we were watching
+func (b *Bridge) checkFirmware(update *philips.Reported) {
+	fw := firmware{
+		version: update.FirmwareVersion,
+		wifi:    update.WiFiVersion,
+		ota:     update.OTA,
+	}
+
+	b.mu.Lock()
+	prev := b.firmware
+	b.firmware = fw
+	b.mu.Unlock()
+
+	if prev == fw {
+		return
+	}
+	b.tr.Publish(b.topic+"/firmware/swversion", []byte(fw.version), true)
+	b.tr.Publish(b.topic+"/firmware/wifiVersion", []byte(fw.wifi), true)
+	b.tr.Publish(b.topic+"/firmware/ota", []byte(fw.ota), true)
+
+	if prev == (firmware{}) {
+		return
+	}
+	if prev.version != fw.version || prev.wifi != fw.wifi {
+		b.logger.Info("firmware changed", "from", prev.version+"/"+prev.wifi, "to", fw.version+"/"+fw.wifi)
+		b.alert(Alert{
+			Event:    EventFirmware,
+			Message:  fmt.Sprintf("firmware updated from %s to %s, WiFi from %s to %s", prev.version, fw.version, prev.wifi, fw.wifi),
+			Severity: SeverityInfo,
+			Time:     time.Now(),
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package bridge
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// haEntity is the discovery config of a single Home Assistant entity. Only
+// the fields we need are included, see
+// https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery
+type haEntity struct {
+	component string
+	object    string
+	// feature is the feature the entity gets its state from
+	feature string
+
+	Name     string    `json:"name"`
+	UniqueID string    `json:"unique_id"`
+	Device   *haDevice `json:"device"`
+	// Availability is decided by whichever of the topics got a message
+	// last, see haAvailability
+	Availability     []haAvailability `json:"availability"`
+	AvailabilityMode string           `json:"availability_mode"`
+
+	StateTopic   string `json:"state_topic,omitempty"`
+	CommandTopic string `json:"command_topic,omitempty"`
+	PayloadOn    string `json:"payload_on,omitempty"`
+	PayloadOff   string `json:"payload_off,omitempty"`
+	// StateValueTemplate turns what's on StateTopic into PayloadOn or
+	// PayloadOff
+	StateValueTemplate string `json:"state_value_template,omitempty"`
+
+	DeviceClass       string `json:"device_class,omitempty"`
+	UnitOfMeasurement string `json:"unit_of_measurement,omitempty"`
+	StateClass        string `json:"state_class,omitempty"`
+
+	PercentageStateTopic   string `json:"percentage_state_topic,omitempty"`
+	PercentageCommandTopic string `json:"percentage_command_topic,omitempty"`
+
+	TargetHumidityStateTopic   string `json:"target_humidity_state_topic,omitempty"`
+	TargetHumidityCommandTopic string `json:"target_humidity_command_topic,omitempty"`
+	MinHumidity                int    `json:"min_humidity,omitempty"`
+	MaxHumidity                int    `json:"max_humidity,omitempty"`
+}
+
+// haAvailability is a topic that tells Home Assistant whether the device is
+// available
+type haAvailability struct {
+	Topic               string `json:"topic"`
+	PayloadNotAvailable string `json:"payload_not_available,omitempty"`
+}
+
+// leaveTopic is where the last will of the MQTT connection goes, with the
+// ClientID as its payload. That's how Hemtjänst finds out the devices of a
+// connection are gone, and <topic>/availability can't: it's retained as
+// "online" when the bridge dies without saying goodbye
+const leaveTopic = "leave"
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+}
+
+var haInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// haEntities returns the Home Assistant entities for the device. The
+// entities use the topics of the Hemtjänst features, so nothing is
+// published twice
+func (b *Bridge) haEntities() []*haEntity {
+	dev := &haDevice{
+		Identifiers:  []string{b.info.DeviceID},
+		Name:         b.name(),
+		Manufacturer: b.manufacturer(),
+		Model:        b.model(),
+	}
+	state := func(name string) string { return b.getTopic(name) }
+	command := func(name string) string { return b.setTopic(name) }
+
+	entities := []*haEntity{
+		{
+			feature:                "on",
+			component:              "fan",
+			object:                 "fan",
+			Name:                   "Fan",
+			StateTopic:             state("on"),
+			CommandTopic:           command("on"),
+			PayloadOn:              "1",
+			PayloadOff:             "0",
+			PercentageStateTopic:   state("rotationSpeed"),
+			PercentageCommandTopic: command("rotationSpeed"),
+		},
+		{
+			// The target state is always 1, since the device can't
+			// dehumidify. Whether it's humidifying shows in the current
+			// state, 2 when it is and 0 when it's only purifying
+			feature:                    "currentHumidifierDehumidifierState",
+			component:                  "humidifier",
+			object:                     "humidifier",
+			Name:                       "Humidifier",
+			DeviceClass:                "humidifier",
+			StateTopic:                 state("currentHumidifierDehumidifierState"),
+			StateValueTemplate:         "{{ '1' if value == '2' else '0' }}",
+			CommandTopic:               command("targetHumidifierDehumidifierState"),
+			PayloadOn:                  "1",
+			PayloadOff:                 "0",
+			TargetHumidityStateTopic:   state("targetRelativeHumidity"),
+			TargetHumidityCommandTopic: command("targetRelativeHumidity"),
+			MinHumidity:                40,
+			MaxHumidity:                70,
+		},
+		{
+			feature:           "pm2_5Density",
+			component:         "sensor",
+			object:            "pm25",
+			Name:              "PM2.5",
+			StateTopic:        state("pm2_5Density"),
+			DeviceClass:       "pm25",
+			UnitOfMeasurement: "µg/m³",
+			StateClass:        "measurement",
+		},
+		{
+			feature:     "aqi",
+			component:   "sensor",
+			object:      "aqi",
+			Name:        "AQI",
+			StateTopic:  state("aqi"),
+			DeviceClass: "aqi",
+			StateClass:  "measurement",
+		},
+		{
+			feature:    "airQuality",
+			component:  "sensor",
+			object:     "air_quality",
+			Name:       "Air quality",
+			StateTopic: state("airQuality"),
+			StateClass: "measurement",
+		},
+		{
+			feature:           "currentRelativeHumidity",
+			component:         "sensor",
+			object:            "humidity",
+			Name:              "Humidity",
+			StateTopic:        state("currentRelativeHumidity"),
+			DeviceClass:       "humidity",
+			UnitOfMeasurement: "%",
+			StateClass:        "measurement",
+		},
+		{
+			feature:           "currentTemperature",
+			component:         "sensor",
+			object:            "temperature",
+			Name:              "Temperature",
+			StateTopic:        state("currentTemperature"),
+			DeviceClass:       "temperature",
+			UnitOfMeasurement: "°C",
+			StateClass:        "measurement",
+		},
+		{
+			feature:           "waterLevel",
+			component:         "sensor",
+			object:            "water_level",
+			Name:              "Water level",
+			StateTopic:        state("waterLevel"),
+			UnitOfMeasurement: "%",
+			StateClass:        "measurement",
+		},
+		{
+			feature:     "tankEmpty",
+			component:   "binary_sensor",
+			object:      "tank_empty",
+			Name:        "Water tank empty",
+			StateTopic:  state("tankEmpty"),
+			DeviceClass: "problem",
+			PayloadOn:   "1",
+			PayloadOff:  "0",
+		},
+		{
+			feature:     "tankOpen",
+			component:   "binary_sensor",
+			object:      "tank_open",
+			Name:        "Water tank open",
+			StateTopic:  state("tankOpen"),
+			DeviceClass: "opening",
+			PayloadOn:   "1",
+			PayloadOff:  "0",
+		},
+		{
+			feature:     "filterChangeIndication",
+			component:   "binary_sensor",
+			object:      "filter",
+			Name:        "Filter",
+			StateTopic:  state("filterChangeIndication"),
+			DeviceClass: "problem",
+			PayloadOn:   "1",
+			PayloadOff:  "0",
+		},
+	}
+
+	res := entities[:0]
+	for _, e := range entities {
+		if !b.supports(e.feature) {
+			continue
+		}
+		res = append(res, e)
+		e.UniqueID = b.info.DeviceID + "_" + e.object
+		e.Device = dev
+		e.Availability = b.haAvailability()
+		e.AvailabilityMode = "latest"
+	}
+	return res
+}
+
+// haAvailability returns the availability topics of the entities. The
+// availability topic says when the device goes offline or comes back, and
+// the last will of the connection when the bridge itself is gone. Once the
+// bridge is back it publishes the availability again, which has the final
+// say since it's the latest
+func (b *Bridge) haAvailability() []haAvailability {
+	res := []haAvailability{{Topic: b.topic + "/availability"}}
+	if b.opts.LastWillID != "" {
+		res = append(res, haAvailability{Topic: leaveTopic, PayloadNotAvailable: b.opts.LastWillID})
+	}
+	return res
+}
+
+// announceHomeAssistant publishes the Home Assistant discovery configs for
+// the device under prefix
+func (b *Bridge) announceHomeAssistant(prefix string) {
+	node := haInvalid.ReplaceAllString(b.info.DeviceID, "_")
+	for _, e := range b.haEntities() {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			b.logger.Error("failed to encode Home Assistant config", "object", e.object, "err", err)
+			continue
+		}
+		topic := strings.Join([]string{prefix, e.component, node, e.object, "config"}, "/")
+		b.tr.Publish(topic, payload, true)
+	}
+}
+
+// handleHomeAssistant announces the device to Home Assistant, and announces
+// it again every time Home Assistant comes online
+func (b *Bridge) handleHomeAssistant(prefix string) {
+	b.announceHomeAssistant(prefix)
+	for msg := range b.tr.Subscribe(prefix + "/status") {
+		if string(msg) == "online" {
+			b.announceHomeAssistant(prefix)
+		}
+	}
+}
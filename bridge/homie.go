@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	homieVersion = "4.0.0"
+	homieNode    = "purifier"
+)
+
+// homieProperty describes how a feature is exposed as a Homie property
+type homieProperty struct {
+	datatype string
+	unit     string
+	format   string
+}
+
+// homieProperties maps the features onto Homie properties. Features with a
+// setter are settable
+var homieProperties = map[string]homieProperty{
+	"on":                                 {datatype: "boolean"},
+	"brightness":                         {datatype: "integer", unit: "%", format: "0:100"},
+	"currentAirPurifierState":            {datatype: "integer", format: "0:2"},
+	"targetAirPurifierState":             {datatype: "integer", format: "0:1"},
+	"currentFanState":                    {datatype: "integer", format: "0:2"},
+	"targetFanState":                     {datatype: "integer", format: "0:1"},
+	"rotationSpeed":                      {datatype: "integer", unit: "%", format: "0:100"},
+	"lockPhysicalControls":               {datatype: "boolean"},
+	"airQuality":                         {datatype: "integer", format: "0:5"},
+	"pm2_5Density":                       {datatype: "integer", unit: "µg/m³"},
+	"aqi":                                {datatype: "integer", format: "0:500"},
+	"pm2_5DensityLimited":                {datatype: "integer", unit: "µg/m³", format: "0:100"},
+	"filterChangeIndication":             {datatype: "boolean"},
+	"currentRelativeHumidity":            {datatype: "integer", unit: "%", format: "0:100"},
+	"targetRelativeHumidity":             {datatype: "integer", unit: "%", format: "40:70"},
+	"currentHumidifierDehumidifierState": {datatype: "integer", format: "0:3"},
+	"targetHumidifierDehumidifierState":  {datatype: "integer", format: "0:2"},
+	"currentTemperature":                 {datatype: "integer", unit: "°C"},
+	"tankEmpty":                          {datatype: "boolean"},
+	"tankOpen":                           {datatype: "boolean"},
+	"waterLevel":                         {datatype: "integer", unit: "%", format: "0:100"},
+}
+
+var homieInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// homieID turns a feature name into a valid Homie ID, rotationSpeed becomes
+// rotation-speed
+func homieID(name string) string {
+	var sb strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			sb.WriteRune('-')
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return strings.Trim(homieInvalid.ReplaceAllString(sb.String(), "-"), "-")
+}
+
+// homieValue converts a feature value into its Homie representation
+func homieValue(name, value string) string {
+	if homieProperties[name].datatype != "boolean" {
+		return value
+	}
+	if value == "1" {
+		return "true"
+	}
+	return "false"
+}
+
+// homieDevice returns the topic of the device in the Homie convention
+func (b *Bridge) homieDevice() string {
+	return b.opts.Homie + "/" + homieID(strings.ToLower(b.info.DeviceID))
+}
+
+// homiePublish publishes a feature value on its Homie property
+func (b *Bridge) homiePublish(name, value string) {
+	if b.opts.Homie == "" {
+		return
+	}
+	if _, ok := homieProperties[name]; !ok || !b.supports(name) {
+		return
+	}
+	b.tr.Publish(b.homieDevice()+"/"+homieNode+"/"+homieID(name), []byte(homieValue(name, value)), true)
+}
+
+// homieState publishes the $state of the Homie device
+func (b *Bridge) homieState(state string) {
+	if b.opts.Homie == "" {
+		return
+	}
+	b.tr.Publish(b.homieDevice()+"/$state", []byte(state), true)
+}
+
+// handleHomie announces the device following the Homie convention and
+// handles set requests for its settable properties
+func (b *Bridge) handleHomie() {
+	dev := b.homieDevice()
+	node := dev + "/" + homieNode
+	pub := func(topic, value string) {
+		b.tr.Publish(topic, []byte(value), true)
+	}
+
+	b.homieState("init")
+	pub(dev+"/$homie", homieVersion)
+	pub(dev+"/$name", b.name())
+	pub(dev+"/$nodes", homieNode)
+	pub(dev+"/$extensions", "")
+	pub(node+"/$name", "Air purifier")
+	pub(node+"/$type", b.model())
+
+	sets := setters()
+	ids := make([]string, 0, len(homieProperties))
+	for name, p := range homieProperties {
+		if !b.supports(name) {
+			continue
+		}
+		id := homieID(name)
+		ids = append(ids, id)
+		prop := node + "/" + id
+		pub(prop+"/$name", name)
+		pub(prop+"/$datatype", p.datatype)
+		if p.unit != "" {
+			pub(prop+"/$unit", p.unit)
+		}
+		if p.format != "" {
+			pub(prop+"/$format", p.format)
+		}
+
+		fn, ok := sets[name]
+		if !ok {
+			continue
+		}
+		pub(prop+"/$settable", "true")
+		go func(name, topic string, fn setter) {
+			for msg := range b.tr.Subscribe(topic) {
+				b.apply(name, string(msg), fn)
+			}
+		}(name, prop+"/set", fn)
+	}
+	sort.Strings(ids)
+	pub(node+"/$properties", strings.Join(ids, ","))
+
+	b.logger.Info("publishing Homie device", "topic", dev)
+	b.homieState("ready")
+}
@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// gauge sets a gauge for the device, if metrics are enabled
+func (b *Bridge) gauge(name string, v float64, labels ...string) {
+	if b.opts.Metrics == nil {
+		return
+	}
+	b.opts.Metrics.Set(name, v, append([]string{"device", b.info.DeviceID}, labels...)...)
+}
+
+// count increments a counter for the device, if metrics are enabled
+func (b *Bridge) count(name string) {
+	if b.opts.Metrics == nil {
+		return
+	}
+	b.opts.Metrics.Add(name, 1, "device", b.info.DeviceID)
+}
+
+// observeCommand records how long a command to the device took
+func (b *Bridge) observeCommand(d time.Duration) {
+	if b.opts.Metrics == nil {
+		return
+	}
+	b.opts.Metrics.Observe("klimat_command_duration_seconds", d.Seconds(), "device", b.info.DeviceID)
+}
+
+// updateMetrics sets the sensor gauges from the reported state, for the
+// sensors the device has
+func (b *Bridge) updateMetrics(r *philips.Reported) {
+	if b.opts.Metrics == nil {
+		return
+	}
+	b.gauge("klimat_pm25_density", float64(r.ParticulateMatter25))
+	if b.supports("currentRelativeHumidity") {
+		b.gauge("klimat_relative_humidity_percent", float64(r.RelativeHumidity))
+	}
+	if b.supports("currentTemperature") {
+		b.gauge("klimat_temperature_celsius", float64(r.Temperature))
+	}
+	if b.supports("waterLevel") {
+		b.gauge("klimat_water_level_percent", float64(r.WaterLevel))
+	}
+	for _, f := range filters {
+		if b.supports(f.name + "HoursRemaining") {
+			b.gauge("klimat_filter_hours_remaining", float64(f.left(r)), "filter", f.name)
+		}
+	}
+}
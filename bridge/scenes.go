@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// parseScenes turns the settings of every scene into the command that
+// applies it
+func parseScenes(scenes map[string]philips.Settings) (map[string]*philips.Desired, error) {
+	res := map[string]*philips.Desired{}
+	for name, s := range scenes {
+		if len(s) == 0 {
+			return nil, fmt.Errorf("scene %q has no settings", name)
+		}
+		msg, err := s.Desired()
+		if err != nil {
+			return nil, fmt.Errorf("scene %q: %w", name, err)
+		}
+		res[name] = msg
+	}
+	return res, nil
+}
+
+// handleScenes applies the scene whose name is published to
+// <topic>/scene/set, all settings in one command. The scene is confirmed
+// on <topic>/scene
+func (b *Bridge) handleScenes() {
+	for msg := range b.tr.Subscribe(b.topic + "/scene/set") {
+		name := strings.TrimSpace(string(msg))
+		cmd, ok := b.scenes[name]
+		if !ok {
+			b.logger.Warn("ignoring unknown scene", "scene", name)
+			continue
+		}
+		if err := b.set(cmd); err != nil {
+			b.logger.Warn("failed to apply scene", "scene", name, "err", err)
+			continue
+		}
+		b.logger.Info("applied scene", "scene", name)
+		b.tr.Publish(b.topic+"/scene", []byte(name), false)
+		b.confirm(cmd)
+	}
+}
@@ -0,0 +1,298 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"hemtjan.st/klimat/automation"
+	"hemtjan.st/klimat/philips"
+)
+
+// setter turns the value a feature is set to into the command for the
+// device. It gets the last reported state, which can be nil if the device
+// hasn't reported anything yet
+type setter func(value string, current *philips.Reported) (*philips.Desired, error)
+
+// setters returns the setter for every feature we can control
+func setters() map[string]setter {
+	return map[string]setter{
+		"on":                                setPower,
+		"brightness":                        setBrightness,
+		"lightOn":                           setLight,
+		"rotationSpeed":                     setRotationSpeed,
+		"targetRelativeHumidity":            setHumidityTarget,
+		"targetHumidifierDehumidifierState": setFunction,
+		"targetAirPurifierState":            setTargetState,
+		"targetFanState":                    setTargetState,
+	}
+}
+
+// handleSets subscribes to the set topics of the features we can control
+func (b *Bridge) handleSets() {
+	for name, fn := range setters() {
+		b.onSet(name, fn)
+	}
+	go b.handleControl()
+}
+
+// handleControl forwards any desired state JSON published to
+// <topic>/control to the device as is
+func (b *Bridge) handleControl() {
+	topic := b.topic + "/control"
+	for msg := range b.tr.Subscribe(topic) {
+		if err := b.SetRaw(msg); err != nil {
+			b.logger.Warn("failed to send desired state to the device", "topic", topic, "state", string(msg), "err", err)
+			continue
+		}
+		b.logger.Info("sent desired state to the device", "state", string(msg))
+	}
+}
+
+// SetRaw sends a desired state JSON object to the device as is, through the
+// connection of the bridge. The automations don't limit it
+func (b *Bridge) SetRaw(desired []byte) error {
+	b.mu.Lock()
+	cl := b.cl
+	b.mu.Unlock()
+
+	if cl == nil {
+		return fmt.Errorf("not connected to a device")
+	}
+	b.setMu.Lock()
+	defer b.setMu.Unlock()
+	return cl.SetRaw(desired)
+}
+
+func (b *Bridge) onSet(name string, fn setter) {
+	for _, d := range b.devs {
+		if !d.has(name) {
+			continue
+		}
+		err := d.feature(name).OnSetFunc(func(value string) {
+			b.apply(name, value, fn)
+		})
+		if err != nil {
+			b.logger.Error("failed to subscribe to set requests", "feature", name, "err", err)
+		}
+	}
+}
+
+// apply handles a request to set a feature to value
+func (b *Bridge) apply(name, value string, fn setter) {
+	msg, err := fn(value, b.reported())
+	if err != nil {
+		b.logger.Warn("ignoring set request", "feature", name, "value", value, "err", err)
+		return
+	}
+	if err := b.set(msg); err != nil {
+		b.logger.Warn("failed to set feature", "feature", name, "value", value, "err", err)
+		return
+	}
+	b.logger.Info("changed feature", "feature", name, "value", value)
+	b.confirm(msg)
+}
+
+// confirm publishes the values the device accepted right away, for the
+// settings where what we sent differs from what was requested because the
+// device only supports certain steps
+func (b *Bridge) confirm(msg *philips.Desired) {
+	if msg.RelativeHumidityTarget != nil {
+		b.publish("targetRelativeHumidity", strconv.Itoa(*msg.RelativeHumidityTarget))
+	}
+}
+
+// Set changes settings on the device, by the names and values of the
+// control subcommand, in one command. It goes through the connection of the
+// bridge, like a set request over MQTT does
+func (b *Bridge) Set(settings philips.Settings) error {
+	msg, err := settings.Desired()
+	if err != nil {
+		return err
+	}
+	if err := b.set(msg); err != nil {
+		return err
+	}
+	b.logger.Info("changed settings", "settings", settings)
+	b.confirm(msg)
+	return nil
+}
+
+// set sends a command to the device we're currently connected to. With
+// FanRamp, a fan speed more than one step away from the current one is
+// reached through the speeds in between
+func (b *Bridge) set(msg *philips.Desired) error {
+	b.mu.Lock()
+	cl := b.cl
+	b.mu.Unlock()
+
+	if cl == nil {
+		return fmt.Errorf("not connected to a device")
+	}
+	msg = b.limit(time.Now(), msg)
+
+	// Commands could otherwise end up in the middle of a ramp
+	b.setMu.Lock()
+	defer b.setMu.Unlock()
+
+	for i, speed := range b.ramp(msg) {
+		step := &philips.Desired{FanSpeed: &speed}
+		if i == 0 {
+			// Whatever else the command changes, like switching to
+			// manual mode, is needed for the first step already
+			step = &philips.Desired{Power: msg.Power, Mode: msg.Mode, FanSpeed: &speed}
+		}
+		if err := b.send(cl, step); err != nil {
+			return err
+		}
+		time.Sleep(b.opts.FanRamp)
+	}
+	return b.send(cl, msg)
+}
+
+// send sends a single command, and records how long it took
+func (b *Bridge) send(cl *philips.Device, msg *philips.Desired) error {
+	start := time.Now()
+	err := cl.Set(msg)
+	b.observeCommand(time.Since(start))
+	return err
+}
+
+// ramp returns the fan speeds to go through before the one msg sets, if
+// it's more than one step away from the current speed
+func (b *Bridge) ramp(msg *philips.Desired) []philips.FanSpeed {
+	if b.opts.FanRamp <= 0 || msg.FanSpeed == nil {
+		return nil
+	}
+	state := b.reported()
+	if state == nil || state.Power != philips.On {
+		return nil
+	}
+	from, to := state.FanSpeed.Step(), msg.FanSpeed.Step()
+	if from < 0 || to < 0 {
+		return nil
+	}
+
+	var steps []philips.FanSpeed
+	for from < to-1 {
+		from++
+		steps = append(steps, philips.FanSpeeds[from])
+	}
+	for from > to+1 {
+		from--
+		steps = append(steps, philips.FanSpeeds[from])
+	}
+	return steps
+}
+
+// limit applies the limits of automations, like quiet hours, to a command
+func (b *Bridge) limit(now time.Time, msg *philips.Desired) *philips.Desired {
+	for _, a := range b.opts.Automations {
+		if l, ok := a.(automation.Limiter); ok {
+			msg = l.Limit(now, msg)
+		}
+	}
+	return msg
+}
+
+func setPower(value string, _ *philips.Reported) (*philips.Desired, error) {
+	var v philips.Power
+	switch value {
+	case "1", "true":
+		v = philips.On
+	case "0", "false":
+		v = philips.Off
+	default:
+		return nil, fmt.Errorf("invalid power state")
+	}
+	return &philips.Desired{Power: &v}, nil
+}
+
+func setBrightness(value string, _ *philips.Reported) (*philips.Desired, error) {
+	v, err := philips.BrightnessFromHemtjanst(value)
+	if err != nil {
+		return nil, err
+	}
+	return &philips.Desired{Brightness: &v}, nil
+}
+
+// setLight turns the light ring on at full brightness, or off
+func setLight(value string, _ *philips.Reported) (*philips.Desired, error) {
+	var v philips.Brightness
+	switch value {
+	case "1", "true":
+		v = philips.Brightness100
+	case "0", "false":
+		v = philips.Brightness0
+	default:
+		return nil, fmt.Errorf("invalid light state")
+	}
+	return &philips.Desired{Brightness: &v}, nil
+}
+
+// setFunction switches humidification on and off. HomeKit has no way to
+// express "purify only" here, so anything other than humidifier (1) turns
+// humidification off. The target state is still reported as 1 afterwards,
+// the current state shows that the device stopped humidifying
+func setFunction(value string, _ *philips.Reported) (*philips.Desired, error) {
+	var f philips.Function
+	switch value {
+	case "1":
+		f = philips.PurificationHumidification
+	case "0", "2":
+		f = philips.Purification
+	default:
+		return nil, fmt.Errorf("invalid target humidifier state")
+	}
+	return &philips.Desired{Function: &f}, nil
+}
+
+func setHumidityTarget(value string, _ *philips.Reported) (*philips.Desired, error) {
+	v, err := philips.HumidityTargetFromHemtjanst(value)
+	if err != nil {
+		return nil, err
+	}
+	return &philips.Desired{RelativeHumidityTarget: &v}, nil
+}
+
+func setRotationSpeed(value string, current *philips.Reported) (*philips.Desired, error) {
+	if value == "0" {
+		// HomeKit sets the speed to 0 when you drag the slider all the way
+		// down, which means the device should turn off
+		v := philips.Off
+		return &philips.Desired{Power: &v}, nil
+	}
+
+	speed, err := philips.FanSpeedFromHemtjanst(value)
+	if err != nil {
+		return nil, err
+	}
+	msg := &philips.Desired{FanSpeed: &speed}
+
+	// The fan speed can only be changed in manual mode, so switch to it if
+	// the device is doing something else
+	if current == nil || current.Mode != philips.Manual {
+		m := philips.Manual
+		msg.Mode = &m
+	}
+	if current == nil || current.Power != philips.On {
+		p := philips.On
+		msg.Power = &p
+	}
+	return msg, nil
+}
+
+// setTargetState handles HomeKit's auto/manual toggle, which is the same for
+// targetAirPurifierState and targetFanState: 0 is manual and 1 is auto
+func setTargetState(value string, _ *philips.Reported) (*philips.Desired, error) {
+	var m philips.Mode
+	switch value {
+	case "0":
+		m = philips.Manual
+	case "1":
+		m = philips.Auto
+	default:
+		return nil, fmt.Errorf("invalid target state")
+	}
+	return &philips.Desired{Mode: &m}, nil
+}
@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"fmt"
+	"math"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// smoother smooths a series of sensor readings
+type smoother interface {
+	// add adds a reading and returns the smoothed value
+	add(v float64) float64
+}
+
+// ema is an exponential moving average
+type ema struct {
+	alpha float64
+	value float64
+	init  bool
+}
+
+func (e *ema) add(v float64) float64 {
+	if !e.init {
+		e.value, e.init = v, true
+		return v
+	}
+	e.value = e.alpha*v + (1-e.alpha)*e.value
+	return e.value
+}
+
+// window is a simple moving average over the last size readings
+type window struct {
+	size   int
+	values []float64
+}
+
+func (w *window) add(v float64) float64 {
+	w.values = append(w.values, v)
+	if len(w.values) > w.size {
+		w.values = w.values[1:]
+	}
+	sum := 0.0
+	for _, v := range w.values {
+		sum += v
+	}
+	return sum / float64(len(w.values))
+}
+
+// smoothed are the sensors smoothing applies to
+var smoothed = []string{"pm25", "humidity", "temperature"}
+
+// newSmoothers returns a smoother for every sensor in smoothed, or nil if
+// smoothing is disabled
+func newSmoothers(alpha float64, size int) (map[string]smoother, error) {
+	switch {
+	case alpha != 0 && size != 0:
+		return nil, fmt.Errorf("use either an alpha or a window for smoothing, not both")
+	case alpha < 0 || alpha > 1:
+		return nil, fmt.Errorf("smoothing alpha must be between 0 and 1")
+	case size < 0:
+		return nil, fmt.Errorf("smoothing window can't be negative")
+	case alpha == 0 && size <= 1:
+		return nil, nil
+	}
+
+	res := make(map[string]smoother, len(smoothed))
+	for _, name := range smoothed {
+		if alpha != 0 {
+			res[name] = &ema{alpha: alpha}
+		} else {
+			res[name] = &window{size: size}
+		}
+	}
+	return res, nil
+}
+
+// smooth returns a copy of the reported state with the PM2.5, humidity and
+// temperature readings smoothed
+func (b *Bridge) smooth(r *philips.Reported) *philips.Reported {
+	if b.smoothers == nil {
+		return r
+	}
+	s := *r
+	add := func(name string, v int) int {
+		return int(math.Round(b.smoothers[name].add(float64(v))))
+	}
+	s.ParticulateMatter25 = add("pm25", r.ParticulateMatter25)
+	s.RelativeHumidity = add("humidity", r.RelativeHumidity)
+	s.Temperature = add("temperature", r.Temperature)
+	return &s
+}
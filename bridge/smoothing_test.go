@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSmoothers(t *testing.T) {
+	tests := []struct {
+		name   string
+		alpha  float64
+		window int
+		in     []float64
+		want   []float64
+	}{
+		{"ema", 0.5, 0, []float64{10, 20, 20, 0}, []float64{10, 15, 17.5, 8.75}},
+		{"ema alpha 1", 1, 0, []float64{10, 20, 5}, []float64{10, 20, 5}},
+		{"window", 0, 3, []float64{10, 20, 30, 40, 0}, []float64{10, 15, 20, 30, 23.333}},
+	}
+	for _, tt := range tests {
+		s, err := newSmoothers(tt.alpha, tt.window)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for _, name := range smoothed {
+			for i, v := range tt.in {
+				if got := s[name].add(v); math.Abs(got-tt.want[i]) > 0.001 {
+					t.Errorf("%s: %s reading %d is %g, want %g", tt.name, name, i, got, tt.want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestNewSmoothers(t *testing.T) {
+	tests := []struct {
+		alpha  float64
+		window int
+		off    bool
+		err    bool
+	}{
+		{0, 0, true, false},
+		{0, 1, true, false},
+		{0.3, 0, false, false},
+		{0, 5, false, false},
+		{0.3, 5, false, true},
+		{-0.1, 0, false, true},
+		{1.1, 0, false, true},
+		{0, -1, false, true},
+	}
+	for _, tt := range tests {
+		s, err := newSmoothers(tt.alpha, tt.window)
+		if (err != nil) != tt.err {
+			t.Errorf("newSmoothers(%g, %d) error = %v, want error %t", tt.alpha, tt.window, err, tt.err)
+			continue
+		}
+		if !tt.err && (s == nil) != tt.off {
+			t.Errorf("newSmoothers(%g, %d) = %v, want disabled %t", tt.alpha, tt.window, s, tt.off)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// stateFile is what's written to the state file of a device
+type stateFile struct {
+	Seen   time.Time       `json:"seen"`
+	Status json.RawMessage `json:"status"`
+}
+
+// statePath returns where the last state of the device is kept
+func (b *Bridge) statePath() string {
+	return filepath.Join(b.opts.StateDir, b.info.DeviceID+".json")
+}
+
+// saveState writes the decrypted status JSON to the state file. It's
+// written to a temporary file first, so a crash never leaves half a file
+func (b *Bridge) saveState(plain []byte, seen time.Time) error {
+	data, err := json.Marshal(stateFile{Seen: seen, Status: plain})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(b.opts.StateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	path := b.statePath()
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// restoreState publishes the state from the state file, if there is one,
+// with <topic>/cached set to true. That stays until the device sends a
+// notification
+func (b *Bridge) restoreState() {
+	data, err := ioutil.ReadFile(b.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			b.logger.Warn("failed to read state", "path", b.statePath(), "err", err)
+		}
+		return
+	}
+	var f stateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		b.logger.Warn("failed to parse state", "path", b.statePath(), "err", err)
+		return
+	}
+	status, err := philips.ParseStatus(f.Status)
+	if err != nil {
+		b.logger.Warn("failed to parse state", "path", b.statePath(), "err", err)
+		return
+	}
+
+	b.mu.Lock()
+	if b.state != nil {
+		// The device beat us to it
+		b.mu.Unlock()
+		return
+	}
+	state := b.smooth(status.State.Reported)
+	b.state = state
+	b.cached = true
+	b.mu.Unlock()
+
+	b.logger.Info("publishing cached state until the device reports", "seen", f.Seen)
+	b.tr.Publish(b.topic+"/cached", []byte("true"), true)
+	b.tr.Publish(b.topic+"/lastSeen", []byte(f.Seen.UTC().Format(time.RFC3339)), true)
+	b.update(state)
+}
@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"strings"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// vacationSettings are what the device runs on during vacation mode: it
+// only purifies, at the lowest fan speed with the light ring off
+var vacationSettings = philips.Settings{
+	"function":   "purification",
+	"mode":       "manual",
+	"fan":        "silent",
+	"brightness": "off",
+}
+
+// handleVacation switches vacation mode on or off when true or false is
+// published to <topic>/vacation/set
+func (b *Bridge) handleVacation() {
+	b.tr.Publish(b.topic+"/vacation", []byte("false"), true)
+	for msg := range b.tr.Subscribe(b.topic + "/vacation/set") {
+		switch strings.ToLower(strings.TrimSpace(string(msg))) {
+		case "1", "true", "on":
+			b.setVacation(true)
+		case "0", "false", "off":
+			b.setVacation(false)
+		default:
+			b.logger.Warn("ignoring invalid vacation mode", "value", string(msg))
+		}
+	}
+}
+
+// handlePresence ends vacation mode when anything is published to the
+// PresenceTopic
+func (b *Bridge) handlePresence() {
+	for range b.tr.Subscribe(b.opts.PresenceTopic) {
+		if b.onVacation() {
+			b.logger.Info("presence detected, ending vacation mode")
+			b.setVacation(false)
+		}
+	}
+}
+
+// onVacation reports if vacation mode is on
+func (b *Bridge) onVacation() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.vacation
+}
+
+// setVacation switches vacation mode on, remembering the settings it
+// changes, or off, putting them back
+func (b *Bridge) setVacation(on bool) {
+	b.mu.Lock()
+	state, saved := b.state, b.vacationSaved
+	if b.vacation == on {
+		b.mu.Unlock()
+		return
+	}
+	b.mu.Unlock()
+
+	var msg *philips.Desired
+	if on {
+		saved = philips.Settings{}
+		if state != nil {
+			all := state.Settings()
+			for name := range vacationSettings {
+				if v, ok := all[name]; ok {
+					saved[name] = v
+				}
+			}
+		}
+		// The settings are fixed, so this can't fail
+		msg, _ = vacationSettings.Desired()
+	} else if len(saved) > 0 {
+		var err error
+		if msg, err = saved.Desired(); err != nil {
+			b.logger.Warn("failed to restore settings after vacation mode", "err", err)
+		}
+		saved = nil
+	}
+
+	if msg != nil {
+		if err := b.set(msg); err != nil {
+			b.logger.Warn("failed to switch vacation mode", "on", on, "err", err)
+			return
+		}
+	}
+
+	b.mu.Lock()
+	b.vacation, b.vacationSaved = on, saved
+	b.mu.Unlock()
+
+	b.logger.Info("switched vacation mode", "on", on)
+	value := "false"
+	if on {
+		value = "true"
+	}
+	b.tr.Publish(b.topic+"/vacation", []byte(value), true)
+}
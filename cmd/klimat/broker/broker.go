@@ -0,0 +1,133 @@
+// Package broker sets up the MQTT connection shared by the subcommands that
+// publish to MQTT
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/cmd/klimat/exitcode"
+	"lib.hemtjan.st/transport/mqtt"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	// stableAfter is how long a connection has to stay up before the
+	// backoff is reset
+	stableAfter = time.Minute
+	// upAfter is how long Start has to keep running before we consider the
+	// connection to be up
+	upAfter = 2 * time.Second
+)
+
+// Stats describe the state of the MQTT connection
+type Stats struct {
+	Connected  bool
+	Reconnects int
+	LastError  error
+	Since      time.Time
+}
+
+// Conn is an MQTT connection that is kept up for as long as its
+// context lives. It never gives up, losing the broker only means the
+// connection is retried with a backoff until the broker is back
+type Conn struct {
+	mqtt.MQTT
+
+	mu          sync.Mutex
+	stats       Stats
+	onReconnect []func()
+}
+
+// Connect creates an MQTT client and keeps it connected in the background
+func Connect(ctx context.Context, config *mqtt.Config) (*Conn, error) {
+	tr, err := mqtt.New(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error creating MQTT client: %v", exitcode.ErrMQTT, err)
+	}
+
+	c := &Conn{MQTT: tr}
+	go c.supervise(ctx)
+	return c, nil
+}
+
+// OnReconnect registers a function that's called every time the connection
+// comes back up after it was lost. Use it to publish the current state
+// again, anything published while the connection was down may be lost
+func (c *Conn) OnReconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// Stats returns the current state of the connection
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *Conn) supervise(ctx context.Context) {
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		reconnect := attempt > 0
+		up := time.AfterFunc(upAfter, func() { c.up(reconnect) })
+
+		_, err := c.Start()
+		up.Stop()
+		if ctx.Err() != nil {
+			// We're shutting down, not losing the broker
+			return
+		}
+
+		c.mu.Lock()
+		c.stats.Connected = false
+		c.stats.LastError = err
+		c.stats.Since = time.Now()
+		c.mu.Unlock()
+
+		if time.Since(started) > stableAfter {
+			backoff = minBackoff
+		}
+		// Add up to 50% jitter so a fleet of bridges doesn't hit a broker
+		// that just restarted all at once
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		slog.Warn("lost connection to MQTT", "subsystem", "mqtt", "retry", wait.Round(time.Millisecond), "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// up marks the connection as up, and runs the OnReconnect functions if it
+// came back after being lost
+func (c *Conn) up(reconnect bool) {
+	c.mu.Lock()
+	c.stats.Connected = true
+	c.stats.Since = time.Now()
+	if reconnect {
+		c.stats.Reconnects++
+	}
+	fns := append([]func(){}, c.onReconnect...)
+	c.mu.Unlock()
+
+	if !reconnect {
+		return
+	}
+	slog.Info("reconnected to MQTT", "subsystem", "mqtt")
+	for _, fn := range fns {
+		fn()
+	}
+}
@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"hemtjan.st/klimat/cmd/klimat/exitcode"
+	"lib.hemtjan.st/transport/mqtt"
+)
+
+// Flags registers the MQTT flags from lib.hemtjan.st on fs, together with
+// flags to configure TLS for the connection. The returned function builds
+// the configuration once the flags have been parsed
+func Flags(fs *flag.FlagSet) func() (*mqtt.Config, error) {
+	mqCfg := mqtt.MustFlags(fs.String, fs.Bool)
+	ca := fs.String("mqtt-ca", "", "PEM file with the CA certificates to verify the broker with, enables TLS")
+	cert := fs.String("mqtt-cert", "", "PEM file with the client certificate to authenticate with, enables TLS")
+	key := fs.String("mqtt-key", "", "PEM file with the private key for -mqtt-cert")
+	insecure := fs.Bool("mqtt-insecure", false, "don't verify the certificate of the broker, enables TLS")
+
+	return func() (*mqtt.Config, error) {
+		cfg := mqCfg()
+		if *ca == "" && *cert == "" && !*insecure {
+			return cfg, nil
+		}
+
+		tlsCfg := cfg.TLS
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		tlsCfg.InsecureSkipVerify = *insecure
+
+		if *ca != "" {
+			pem, err := ioutil.ReadFile(*ca)
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to read CA file: %v", exitcode.ErrMQTT, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%w: no certificates found in %s", exitcode.ErrMQTT, *ca)
+			}
+			tlsCfg.RootCAs = pool
+		}
+
+		if *cert != "" || *key != "" {
+			if *cert == "" || *key == "" {
+				return nil, fmt.Errorf("%w: -mqtt-cert and -mqtt-key must be used together", exitcode.ErrMQTT)
+			}
+			pair, err := tls.LoadX509KeyPair(*cert, *key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to load client certificate: %v", exitcode.ErrMQTT, err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{pair}
+		}
+
+		cfg.TLS = tlsCfg
+		return cfg, nil
+	}
+}
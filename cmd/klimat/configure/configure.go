@@ -0,0 +1,201 @@
+package configure
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/driver"
+	"hemtjan.st/klimat/philips"
+)
+
+type cfg struct {
+	out           io.Writer
+	in            io.Reader
+	file          string
+	discoveryAddr string
+}
+
+// NewCmd returns the config subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := cfg{
+		out: out,
+		in:  os.Stdin,
+	}
+
+	fs := flag.NewFlagSet("klimat config", flag.ExitOnError)
+	fs.StringVar(&c.file, "config", config.DefaultPath(), "path to the configuration file")
+
+	initFs := flag.NewFlagSet("klimat config init", flag.ExitOnError)
+	initFs.StringVar(&c.discoveryAddr, "address", philips.DiscoveryAddress, "host:port for Philips multicast discovery")
+
+	return &ffcli.Command{
+		Name:       "config",
+		ShortUsage: "config [flags] <subcommand>",
+		FlagSet:    fs,
+		ShortHelp:  "Config manages the klimat configuration file",
+		LongHelp: "The config command manages the configuration file that " +
+			"maps aliases to devices. Commands that accept -device can use " +
+			"those aliases instead of an address. The file is JSON, which " +
+			"needs nothing beyond the standard library to read and write.",
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "init",
+				ShortUsage: "init [flags]",
+				ShortHelp:  "Discover devices and add them to the configuration file",
+				LongHelp: "The init command runs the discovery of every driver and " +
+					"asks for an alias for every device it finds, recording the " +
+					"driver the device belongs to. Devices that are already in " +
+					"the configuration file keep their alias, but get their " +
+					"address updated.",
+				FlagSet: initFs,
+				Exec:    c.init,
+			},
+			{
+				Name:       "list",
+				ShortUsage: "list",
+				ShortHelp:  "List the devices in the configuration file",
+				Exec:       c.list,
+			},
+		},
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+func (c *cfg) init(ctx context.Context, args []string) error {
+	conf, err := config.Load(c.file)
+	if err != nil {
+		return err
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	found := map[string]driver.Found{}
+	slog.Info("sending discovery request")
+	err = driver.Discover(dctx, map[string]driver.Probe{
+		"philips": philips.Probe(c.discoveryAddr),
+	}, func(f driver.Found) {
+		if !conf.Allowed(f.Info.ID) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if d, ok := found[f.Info.ID]; ok && !philips.BetterAddress(f.Address, d.Address) {
+			return
+		}
+		found[f.Info.ID] = f
+	})
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(found) == 0 {
+		return fmt.Errorf("no devices responded, try running it again")
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	in := bufio.NewScanner(c.in)
+	for _, id := range ids {
+		f := found[id]
+		address, info := f.Address, f.Info
+
+		if alias, d := conf.ByDeviceID(id); d != nil {
+			d.Address = address
+			d.Name = info.Name
+			d.Model = info.Model
+			fmt.Fprintf(c.out, "updated %s: %s (%s) at %s\n", alias, info.Name, info.Model, address)
+			continue
+		}
+
+		suggested := freeAlias(conf, slug(info.Name))
+		fmt.Fprintf(c.out, "found %s %s (%s) at %s\n", info.Manufacturer, info.Name, info.Model, address)
+		alias := ""
+		for alias == "" {
+			fmt.Fprintf(c.out, "alias [%s]: ", suggested)
+			alias = suggested
+			if in.Scan() {
+				if v := strings.TrimSpace(in.Text()); v != "" {
+					alias = v
+				}
+			}
+			if _, ok := conf.Devices[alias]; ok {
+				fmt.Fprintf(c.out, "alias %s is already in use\n", alias)
+				alias = ""
+			}
+		}
+
+		d := &config.Device{
+			Address:  address,
+			DeviceID: id,
+			Name:     info.Name,
+			Model:    info.Model,
+		}
+		// Devices without a driver use the default one, so only others
+		// are written out
+		if f.Driver != driver.Default {
+			d.Driver = f.Driver
+		}
+		conf.Devices[alias] = d
+	}
+
+	if err := conf.Save(c.file); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "wrote %d device(s) to %s\n", len(conf.Devices), c.file)
+	return nil
+}
+
+func (c *cfg) list(ctx context.Context, args []string) error {
+	conf, err := config.Load(c.file)
+	if err != nil {
+		return err
+	}
+	for _, alias := range conf.Aliases() {
+		d := conf.Devices[alias]
+		fmt.Fprintf(c.out, "%s\t%s\t%s\t%s\n", alias, d.Address, d.Model, d.DeviceID)
+	}
+	return nil
+}
+
+// freeAlias returns alias, or if a device already has it alias with the
+// first number from 2 up that's free appended
+func freeAlias(conf *config.Config, alias string) string {
+	res := alias
+	for i := 2; ; i++ {
+		if _, ok := conf.Devices[res]; !ok {
+			return res
+		}
+		res = fmt.Sprintf("%s-%d", alias, i)
+	}
+}
+
+// slug turns a device name into something that's easy to type
+func slug(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Join(strings.Fields(name), "-")
+	if name == "" {
+		return "device"
+	}
+	return name
+}
@@ -2,19 +2,28 @@ package control
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
-	"log"
-	"strconv"
+	"log/slog"
 	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/daemon"
+	"hemtjan.st/klimat/driver"
 	"hemtjan.st/klimat/philips"
 )
 
 type config struct {
-	out  io.Writer
-	host string
+	opts    func() philips.Options
+	out     io.Writer
+	host    string
+	cfgFile string
+	device  string
+	socket  string
+	direct  bool
 }
 
 // NewCmd returns the discover subcommand
@@ -25,50 +34,62 @@ func NewCmd(out io.Writer) *ffcli.Command {
 
 	fs := flag.NewFlagSet("klimat control", flag.ExitOnError)
 	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	c.opts = philips.OptionsFlags(fs)
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	fs.StringVar(&c.socket, "socket", daemon.DefaultSocket(), "unix socket of a running klimatd, whose connection to -device is used if it has one")
+	fs.BoolVar(&c.direct, "direct", false, "always connect to the device, even if a running klimatd is connected to it")
 
 	subcommands := []*ffcli.Command{
 		{
 			Name:       "brightness",
 			ShortUsage: "brightness on|off|25|50|75",
-			Exec:       c.brightness,
+			Exec:       c.setting("brightness"),
 		},
 		{
 			Name:       "display",
 			ShortUsage: "display humidity|iaq|pm25",
-			Exec:       c.display,
+			Exec:       c.setting("display"),
 		},
 		{
 			Name:       "fan",
 			ShortUsage: "fan silent|1|2|3|turbo",
-			Exec:       c.fanspeed,
+			Exec:       c.setting("fan"),
 		},
 		{
 			Name:       "function",
 			ShortUsage: "function humidification|purification",
 			LongHelp: "The humidification mode implies purification, whereas " +
 				"purification does not imply humidification",
-			Exec: c.function,
+			Exec: c.setting("function"),
 		},
 		{
 			Name:       "humidity",
 			ShortUsage: "humidity 40|50|60|max",
-			Exec:       c.humidity,
+			Exec:       c.setting("humidity"),
 		},
 		{
 			Name:       "lock",
 			ShortUsage: "lock on|yes|off|no",
-			Exec:       c.lock,
+			Exec:       c.setting("lock"),
 		},
 		{
 			Name:       "mode",
 			ShortUsage: "mode auto|allergen|bacteria|manual|night|sleep",
 			LongHelp:   "The supported values vary per device",
-			Exec:       c.mode,
+			Exec:       c.setting("mode"),
 		},
 		{
 			Name:       "power",
 			ShortUsage: "power on|yes|off|no",
-			Exec:       c.power,
+			Exec:       c.setting("power"),
+		},
+		{
+			Name:       "scene",
+			ShortUsage: "scene <name>",
+			LongHelp: "Applies a scene from the configuration file, changing " +
+				"all of its settings in one command",
+			Exec: c.scene,
 		},
 	}
 
@@ -80,270 +101,115 @@ func NewCmd(out io.Writer) *ffcli.Command {
 		ShortHelp:   "Control lets you send commands to a device",
 		LongHelp: "The control command lets you send commands to a device. " +
 			"This lets you change certain settings, like power, the brightness of " +
-			"the ring, the device mode etc.",
+			"the ring, the device mode etc. If a klimatd on -socket is bridging " +
+			"-device the command goes through its connection, since a second " +
+			"session to the device can get the message IDs of both out of sync.",
 		Exec: func(context.Context, []string) error {
 			return flag.ErrHelp
 		},
 	}
 }
 
-func (c *config) brightness(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
-	}
-
-	dest := strings.ToLower(args[0])
-	var v philips.Brightness
-	switch dest {
-	case "on":
-		v = philips.Brightness100
-	case "off":
-		v = philips.Brightness0
-	case "25":
-		v = philips.Brightness25
-	case "50":
-		v = philips.Brightness50
-	case "75":
-		v = philips.Brightness75
-	default:
-		return flag.ErrHelp
-	}
-
-	err = cl.Set(&philips.Desired{Brightness: &v})
-	if err != nil {
-		return err
-	}
-
-	log.Printf("changed value for brigthness to: %s", dest)
-	return nil
-}
-
-func (c *config) display(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
-	}
-
-	dest := strings.ToLower(args[0])
-	var v philips.DisplayMode
-	switch dest {
-	case "iaq":
-		v = philips.IAQ
-	case "humidity":
-		v = philips.Humidity
-	case "pm25":
-		v = philips.PM25
-	default:
-		return flag.ErrHelp
-	}
-
-	err = cl.Set(&philips.Desired{DisplayMode: &v})
-	if err != nil {
-		return err
-	}
-
-	log.Printf("changed value for display mode to: %s", dest)
-	return nil
-}
-
-func (c *config) fanspeed(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
+// connect opens the device with the driver it has in the configuration
+// file. For Philips devices the options from the flags apply, unless the
+// device has its own
+func (c *config) connect(ctx context.Context) (driver.Device, error) {
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	dest := strings.ToLower(args[0])
-	var v philips.FanSpeed
-	switch dest {
-	case "silent":
-		v = philips.Silent
-	case "turbo":
-		v = philips.Turbo
-	case "1":
-		v = philips.Speed1
-	case "2":
-		v = philips.Speed2
-	case "3":
-		v = philips.Speed3
-	default:
-		return flag.ErrHelp
-	}
-
-	err = cl.Set(&philips.Desired{FanSpeed: &v})
-	if err != nil {
-		return err
-	}
-
-	log.Printf("changed value for fan speed to: %s", dest)
-	return nil
-}
-
-func (c *config) function(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
+	var (
+		name    string
+		devOpts map[string]string
+	)
+	if c.device != "" {
+		conf, err := klimatcfg.Load(c.cfgFile)
+		if err != nil {
+			return nil, err
+		}
+		// Devices only in the registry use the default driver
+		if _, d, err := conf.Lookup(c.device); err == nil {
+			name, devOpts = d.Driver, d.Options
+		}
 	}
 
-	dest := strings.ToLower(args[0])
-	var v philips.Function
-	switch dest {
-	case "purification":
-		v = philips.Purification
-	case "humidification":
-		v = philips.PurificationHumidification
-	default:
-		return flag.ErrHelp
+	if name != "" && name != driver.Default {
+		return driver.Open(ctx, name, host, devOpts)
 	}
-
-	err = cl.Set(&philips.Desired{Function: &v})
+	opts, err := philips.ParseOptions(c.opts(), devOpts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	log.Printf("changed value for function speed to: %s", dest)
-	return nil
+	return philips.OpenDriver(ctx, host, opts)
 }
 
-func (c *config) humidity(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
-	}
-
-	dest := strings.ToLower(args[0])
-	var v int
-	switch dest {
-	case "40", "50", "60":
-		v, err = strconv.Atoi(dest)
-		if err != nil {
+// set changes settings through a running daemon if it's bridging the
+// device, or by connecting to the device otherwise
+func (c *config) set(ctx context.Context, settings map[string]string) error {
+	if !c.direct && c.device != "" && c.socket != "" {
+		err := daemon.NewClient(c.socket).Set(ctx, c.device, settings)
+		switch {
+		case err == nil:
+			slog.Debug("sent settings through the daemon", "socket", c.socket)
+			return nil
+		case errors.Is(err, daemon.ErrNotRunning), errors.Is(err, daemon.ErrUnknownDevice):
+			slog.Debug("connecting to the device", "reason", err)
+		default:
 			return err
 		}
-	case "max":
-		v = 70
-	default:
-		return flag.ErrHelp
 	}
 
-	err = cl.Set(&philips.Desired{RelativeHumidityTarget: &v})
+	cl, err := c.connect(ctx)
 	if err != nil {
 		return err
 	}
-
-	log.Printf("changed value for humidity to: %s", dest)
-	return nil
+	defer cl.Close()
+	return cl.Set(settings)
 }
 
-func (c *config) lock(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
-	}
+// setting returns the subcommand handler that changes the named setting,
+// see philips.Settings
+func (c *config) setting(name string) func(context.Context, []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) == 0 {
+			return flag.ErrHelp
+		}
 
-	dest := strings.ToLower(args[0])
-	var v bool
-	switch dest {
-	case "on", "yes":
-		v = true
-	default:
-		v = false
-	}
+		value := strings.ToLower(args[0])
+		if err := c.set(ctx, map[string]string{name: value}); err != nil {
+			return err
+		}
 
-	err = cl.Set(&philips.Desired{ChildLock: &v})
-	if err != nil {
-		return err
+		slog.Info("changed value", "setting", name, "value", value)
+		return nil
 	}
-
-	log.Printf("changed value for (child)lock to: %s", dest)
-	return nil
 }
 
-func (c *config) mode(ctx context.Context, args []string) error {
+// scene applies a scene from the configuration file
+func (c *config) scene(ctx context.Context, args []string) error {
 	if len(args) == 0 {
 		return flag.ErrHelp
 	}
 
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
-	}
-
-	dest := strings.ToLower(args[0])
-	var v philips.Mode
-	switch dest {
-	case "auto":
-		v = philips.Auto
-	case "allergen":
-		v = philips.Allergen
-	case "bacteria":
-		v = philips.Bacteria
-	case "manual":
-		v = philips.Manual
-	case "night":
-		v = philips.Night
-	case "sleep":
-		v = philips.Sleep
-	default:
-		return flag.ErrHelp
-	}
-
-	err = cl.Set(&philips.Desired{Mode: &v})
+	conf, err := klimatcfg.Load(c.cfgFile)
 	if err != nil {
 		return err
 	}
-
-	log.Printf("changed value for mode to: %s", dest)
-	return nil
-}
-
-func (c *config) power(ctx context.Context, args []string) error {
-	if len(args) == 0 {
-		return flag.ErrHelp
-	}
-
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
+	var dev *klimatcfg.Device
+	if c.device != "" {
+		// Devices only in the registry just get the shared scenes
+		_, dev, _ = conf.Lookup(c.device)
 	}
-
-	dest := strings.ToLower(args[0])
-	var v philips.Power
-	switch dest {
-	case "on", "yes":
-		v = philips.On
-	default:
-		v = philips.Off
+	settings, ok := conf.ScenesFor(dev)[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown scene %q", args[0])
 	}
 
-	err = cl.Set(&philips.Desired{Power: &v})
-	if err != nil {
-		return err
+	if err := c.set(ctx, settings); err != nil {
+		return fmt.Errorf("scene %q: %w", args[0], err)
 	}
 
-	log.Printf("changed value for power to: %s", dest)
+	slog.Info("applied scene", "scene", args[0])
 	return nil
 }
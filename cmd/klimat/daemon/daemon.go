@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	klimatd "hemtjan.st/klimat/daemon"
+	"hemtjan.st/klimat/philips"
+)
+
+type config struct {
+	out    io.Writer
+	socket string
+}
+
+// NewCmd returns the daemon subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat daemon", flag.ExitOnError)
+	fs.StringVar(&c.socket, "socket", klimatd.DefaultSocket(), "unix socket the daemon serves its control API on")
+
+	return &ffcli.Command{
+		Name:       "daemon",
+		ShortUsage: "daemon [flags] <subcommand>",
+		FlagSet:    fs,
+		ShortHelp:  "Daemon talks to a running klimatd",
+		LongHelp: "The daemon command talks to a running klimatd, or publish " +
+			"with -socket, over its control API. It uses the connections " +
+			"the daemon already has to the devices instead of opening new " +
+			"ones, which would race with them.",
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "list",
+				ShortUsage: "list",
+				ShortHelp:  "List the devices the daemon is bridging",
+				Exec:       c.list,
+			},
+			{
+				Name:       "status",
+				ShortUsage: "status <device>",
+				ShortHelp:  "Print the last status a device reported, as JSON",
+				Exec:       c.status,
+			},
+			{
+				Name:       "set",
+				ShortUsage: "set <device> <setting>=<value>...",
+				ShortHelp:  "Change settings of a device",
+				LongHelp: "The set command changes settings of a device in one " +
+					"command, using the names and values of the control " +
+					"subcommands, like mode=sleep brightness=off.",
+				Exec: c.set,
+			},
+		},
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+func (c *config) list(ctx context.Context, args []string) error {
+	devices, err := klimatd.NewClient(c.socket).Devices(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		state := "offline"
+		if d.Online {
+			state = "online"
+		}
+		fmt.Fprintf(c.out, "%s\t%s\t%s\t%s\t%s\n", d.Alias, d.DeviceID, d.Model, d.Topic, state)
+	}
+	return nil
+}
+
+func (c *config) status(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	state, err := klimatd.NewClient(c.socket).Status(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+func (c *config) set(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return flag.ErrHelp
+	}
+	settings := philips.Settings{}
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q, expected <setting>=<value>", arg)
+		}
+		settings[name] = value
+	}
+	if _, err := settings.Desired(); err != nil {
+		return err
+	}
+	return klimatd.NewClient(c.socket).Set(ctx, args[0], settings)
+}
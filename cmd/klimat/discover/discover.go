@@ -6,92 +6,330 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/go-ocf/go-coap"
-	"github.com/go-ocf/go-coap/codes"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/cmd/klimat/broker"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/driver"
 	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/transport/mqtt"
+)
+
+const (
+	// missedRounds is how many discovery rounds in a row a device can fail
+	// to respond to in watch mode before we consider it gone. The devices
+	// don't always respond, so one missed round doesn't mean much
+	missedRounds = 3
 )
 
 type config struct {
-	out  io.Writer
-	host string
+	out     io.Writer
+	host    string
+	json    bool
+	timeout time.Duration
+	watch   bool
+	ipv6    bool
+	cfgFile string
+	update  bool
+	publish bool
+	topic   string
+	mqttcfg func() (*mqtt.Config, error)
+	allowed func(id string) bool
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	reg *klimatcfg.Registry
+	mq  mqtt.MQTT
+}
+
+// device is what gets printed for every discovered device with -json
+type device struct {
+	Event    string `json:"event,omitempty"`
+	Driver   string `json:"driver"`
+	Address  string `json:"address"`
+	DeviceID string `json:"device_id"`
+	Model    string `json:"model"`
+	Name     string `json:"name"`
+	Firmware string `json:"firmware"`
+}
+
+// announcement is what gets published for every device with -publish
+type announcement struct {
+	device
+	LastSeen *time.Time `json:"last_seen,omitempty"`
 }
 
 // NewCmd returns the discover subcommand
 func NewCmd(out io.Writer) *ffcli.Command {
-	c := config{
+	c := &config{
 		out:  out,
 		host: "",
+		enc:  json.NewEncoder(out),
 	}
 
 	fs := flag.NewFlagSet("klimat discover", flag.ExitOnError)
-	fs.StringVar(&c.host, "address", "224.0.1.187:5683", "host:port for multicast discovery")
+	c.mqttcfg = broker.Flags(fs)
+	fs.StringVar(&c.host, "address", philips.DiscoveryAddress, "host:port for multicast discovery")
+	fs.BoolVar(&c.json, "json", false, "print every discovered device as a JSON object")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "how long to wait for devices to respond")
+	fs.BoolVar(&c.ipv6, "ipv6", false, "use IPv6 multicast, unless -address is set. Link-local discovery needs a zone, like [ff02::fd%eth0]:5683")
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file, the registry is stored next to it")
+	fs.BoolVar(&c.update, "update-registry", false, "record the discovered devices in the registry")
+	fs.BoolVar(&c.publish, "publish", false, "announce the discovered devices on MQTT")
+	fs.StringVar(&c.topic, "publish-topic", "climate/discovered", "topic prefix for -publish, the DeviceID is appended to it")
+	fs.BoolVar(&c.watch, "watch", false, "keep discovering and print devices as they appear and disappear")
 
 	return &ffcli.Command{
 		Name:       "discover",
 		ShortUsage: "discover [flags]",
 		FlagSet:    fs,
 		ShortHelp:  "Discover compatible devices on the network",
-		LongHelp: "The discover command uses multicat CoAP to discover devices " +
-			"on the network. It implements the same discovery procedure as the " +
+		LongHelp: "The discover command runs the discovery of every driver at " +
+			"once and reports which driver every device belongs to. For Philips " +
+			"devices it uses multicast CoAP, the same discovery procedure as the " +
 			"AirMatters app. The devices can be a bit finicky and may not always " +
 			"respond, so you might have to run this a few times to ensure you get " +
-			"a reply.",
+			"a reply. With -watch it sends a new discovery request every -timeout " +
+			"and reports devices that appear, or disappear after not responding " +
+			"a couple of times in a row. With -publish every device is announced, " +
+			"retained, on MQTT.",
 		Exec: c.Exec,
 	}
 }
 
 func (c *config) Exec(ctx context.Context, args []string) error {
-	client := &coap.MulticastClient{
-		DialTimeout: 5 * time.Second,
+	if c.timeout <= 0 {
+		return flag.ErrHelp
 	}
-
-	conn, err := client.DialWithContext(ctx, c.host)
-	if err != nil {
-		return fmt.Errorf("failed to dial: %w", err)
+	if c.ipv6 && c.host == philips.DiscoveryAddress {
+		c.host = philips.DiscoveryAddressIPv6
 	}
-
-	req, err := conn.NewGetRequest("/sys/dev/info")
+	conf, err := klimatcfg.Load(c.cfgFile)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	log.Print("sending discovery request")
-	wait, err := conn.PublishMsgWithContext(ctx, req, func(req *coap.Request) {
-		m := req.Client.NewMessage(coap.MessageParams{
-			Type:      coap.Reset,
-			Code:      codes.Empty,
-			MessageID: req.Msg.MessageID(),
-		})
-		// I don't believe we should be sending a reset here, but it's what the
-		// AirMatters app does according to packet captures, so lets do it
-		if err := req.Client.WriteMsgWithContext(ctx, m); err != nil {
-			log.Print("failed to send reset")
+		return err
+	}
+	c.allowed = conf.Allowed
+	if c.update {
+		reg, err := klimatcfg.LoadRegistry(klimatcfg.RegistryPath(c.cfgFile))
+		if err != nil {
+			return err
+		}
+		c.reg = reg
+	}
+	if c.publish {
+		cfg, err := c.mqttcfg()
+		if err != nil {
+			return err
+		}
+		mq, err := broker.Connect(ctx, cfg)
+		if err != nil {
+			return err
 		}
+		c.mq = mq
+	}
+	if c.watch {
+		return c.watchDevices(ctx)
+	}
+
+	// Wait for a bit to see if anyone responds
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// Logs go to stdout too, so keep quiet to not mix them with the JSON
+	if !c.json {
+		slog.Info("sending discovery request")
+	}
+	res := newResults()
+	if err := c.discover(ctx, res.add); err != nil {
+		return err
+	}
+
+	devices := res.devices()
+	for _, d := range devices {
+		c.remember(d.Found)
+		c.print("", d.Found)
+	}
+	if !c.json {
+		slog.Info("discovery finished", "devices", len(devices), "responses", res.responses)
+	}
 
-		var info philips.Info
-		if err := json.Unmarshal(req.Msg.Payload(), &info); err != nil {
-			log.Printf("could not decode info: %v", err)
+	if c.mq != nil {
+		// Publishing happens in the background, so give the client a moment
+		// to get the announcements out before we exit
+		time.Sleep(time.Second)
+	}
+	return c.saveRegistry()
+}
+
+// discover runs the discovery of every driver, with the Philips one on
+// -address. Devices the configuration doesn't allow are left out
+func (c *config) discover(ctx context.Context, found func(driver.Found)) error {
+	return driver.Discover(ctx, map[string]driver.Probe{
+		"philips": philips.Probe(c.host),
+	}, func(f driver.Found) {
+		if !c.allowed(f.Info.ID) {
+			slog.Debug("ignoring device that isn't allowed", "device", f.Info.ID, "address", f.Address)
 			return
 		}
-		log.Printf("discovered device at: %s: %+v", req.Client.RemoteAddr().String(), info)
+		found(f)
 	})
-	if err != nil {
-		return fmt.Errorf("failed to do discovery: %w", err)
-	}
-
-	// Wait for a couple of seconds to see if anyone responds
-	for {
-		select {
-		case <-time.After(5 * time.Second):
-			wait.Cancel()
-			return nil
-		case <-ctx.Done():
-			wait.Cancel()
-			return nil
+}
+
+// seen is a device that responded to discovery
+type seen struct {
+	driver.Found
+	missed int
+}
+
+// results collects the responses to a discovery request. Devices tend to
+// respond more than once, and from every interface they have, so only the
+// best address for every ID is kept
+type results struct {
+	mu        sync.Mutex
+	seen      map[string]seen
+	responses int
+}
+
+func newResults() *results {
+	return &results{seen: map[string]seen{}}
+}
+
+func (r *results) add(f driver.Found) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.responses++
+	if s, ok := r.seen[f.Info.ID]; ok && !philips.BetterAddress(f.Address, s.Address) {
+		return
+	}
+	r.seen[f.Info.ID] = seen{Found: f}
+}
+
+// devices returns every device that responded, ordered by ID
+func (r *results) devices() []seen {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]seen, 0, len(r.seen))
+	for _, s := range r.seen {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Info.ID < res[j].Info.ID
+	})
+	return res
+}
+
+func (c *config) watchDevices(ctx context.Context) error {
+	known := map[string]*seen{}
+
+	for ctx.Err() == nil {
+		res := newResults()
+		rctx, cancel := context.WithTimeout(ctx, c.timeout)
+		err := c.discover(rctx, res.add)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		round := map[string]bool{}
+		for _, s := range res.devices() {
+			id := s.Info.ID
+			round[id] = true
+			c.remember(s.Found)
+
+			k, ok := known[id]
+			if !ok {
+				known[id] = &seen{Found: s.Found}
+				c.print("appeared", s.Found)
+				continue
+			}
+			k.missed = 0
+			if k.Address != s.Address {
+				k.Address = s.Address
+				c.print("moved", s.Found)
+			}
+		}
+		for id, k := range known {
+			if round[id] {
+				continue
+			}
+			k.missed++
+			if k.missed >= missedRounds {
+				delete(known, id)
+				c.print("disappeared", k.Found)
+			}
+		}
+
+		if err := c.saveRegistry(); err != nil {
+			slog.Warn("failed to save registry", "err", err)
+		}
+	}
+
+	return nil
+}
+
+// remember records a device in the registry, if we're keeping one
+func (c *config) remember(f driver.Found) {
+	if c.reg == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reg.Seen(f.Info.ID, f.Address, f.Info.Name, f.Info.Model, time.Now())
+}
+
+func (c *config) saveRegistry() error {
+	if c.reg == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reg.Save(klimatcfg.RegistryPath(c.cfgFile))
+}
+
+func (c *config) print(event string, f driver.Found) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := device{
+		Event:    event,
+		Driver:   f.Driver,
+		Address:  f.Address,
+		DeviceID: f.Info.ID,
+		Model:    f.Info.Model,
+		Name:     f.Info.Name,
+		Firmware: f.Info.Firmware,
+	}
+
+	if c.mq != nil {
+		a := announcement{device: d}
+		if event != "disappeared" {
+			now := time.Now()
+			a.LastSeen = &now
 		}
+		payload, err := json.Marshal(a)
+		if err == nil {
+			c.mq.Publish(c.topic+"/"+f.Info.ID, payload, true)
+		}
+	}
+
+	if !c.json {
+		if event == "" {
+			event = "discovered"
+		}
+		slog.Info(event+" device", "device", f.Info.ID, "driver", f.Driver, "address", f.Address, "info", fmt.Sprintf("%+v", f.Info))
+		return
+	}
+
+	if err := c.enc.Encode(d); err != nil {
+		slog.Error("failed to encode device", "err", err)
 	}
 }
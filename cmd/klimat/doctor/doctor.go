@@ -0,0 +1,299 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/cmd/klimat/broker"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/transport/mqtt"
+)
+
+type config struct {
+	opts           func() philips.Options
+	out            io.Writer
+	host           string
+	discoveryAddr  string
+	observeTimeout time.Duration
+	mqtt           bool
+	mqttcfg        func() (*mqtt.Config, error)
+	cfgFile        string
+	device         string
+}
+
+// result is the outcome of a single check
+type result int
+
+const (
+	pass result = iota
+	fail
+	skip
+)
+
+func (r result) String() string {
+	switch r {
+	case pass:
+		return "PASS"
+	case fail:
+		return "FAIL"
+	default:
+		return "SKIP"
+	}
+}
+
+// check is a single diagnostic. Checks are run in order and a check that
+// depends on a previous one is skipped if that one failed
+type check struct {
+	name      string
+	hint      string
+	dependsOn string
+	run       func(ctx context.Context) (string, error)
+}
+
+// NewCmd returns the doctor subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("klimat doctor", flag.ExitOnError)
+	mqCfg := broker.Flags(fs)
+
+	c := config{
+		out:     out,
+		mqttcfg: mqCfg,
+	}
+
+	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	c.opts = philips.OptionsFlags(fs)
+	fs.StringVar(&c.discoveryAddr, "discovery-address", philips.DiscoveryAddress, "host:port for multicast discovery")
+	fs.DurationVar(&c.observeTimeout, "observe-timeout", 15*time.Second, "how long to wait for a status notification")
+	fs.BoolVar(&c.mqtt, "mqtt", false, "also check connectivity to the MQTT broker")
+
+	return &ffcli.Command{
+		Name:       "doctor",
+		ShortUsage: "doctor [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Doctor runs diagnostics against a device",
+		LongHelp: "The doctor command runs a series of checks, from multicast " +
+			"discovery and unicast reachability to setting up a session and " +
+			"receiving status notifications, and prints a report with hints " +
+			"on how to fix whatever failed. Pass -mqtt to also verify the " +
+			"connection to the MQTT broker.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
+	if err != nil {
+		return err
+	}
+	c.host = host
+
+	var dev *philips.Device
+	defer func() {
+		if dev != nil {
+			dev.Close()
+		}
+	}()
+
+	checks := []check{
+		{
+			name: "discovery",
+			hint: "multicast traffic may be blocked between you and the device, " +
+				"check that IGMP snooping or client isolation isn't filtering " +
+				"224.0.1.187. The devices don't always reply, so try again",
+			run: func(ctx context.Context) (string, error) {
+				return c.discover(ctx)
+			},
+		},
+		{
+			name: "reachability",
+			hint: "check the address and port, and that no firewall is dropping " +
+				"UDP traffic to the device",
+			run: func(ctx context.Context) (string, error) {
+				return c.ping(ctx)
+			},
+		},
+		{
+			name:      "handshake",
+			dependsOn: "reachability",
+			hint: "the device answers but didn't accept the session, make sure " +
+				"this is a device that uses the encrypted CoAP protocol",
+			run: func(ctx context.Context) (string, error) {
+				d, err := philips.NewWithOptions(ctx, c.host, c.opts())
+				if err != nil {
+					return "", err
+				}
+				dev = d
+				info, err := d.Info()
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("connected to %s (%s), firmware %s", info.Name, info.ModelID, info.SWVersion), nil
+			},
+		},
+		{
+			name:      "observe",
+			dependsOn: "handshake",
+			hint: "the device didn't send any status notifications, it may be " +
+				"stuck, which can usually be fixed by power cycling it. Also make " +
+				"sure the Air Matters app isn't connected at the same time",
+			run: func(ctx context.Context) (string, error) {
+				return c.observe(ctx, dev)
+			},
+		},
+	}
+
+	if c.mqtt {
+		checks = append(checks, check{
+			name: "mqtt",
+			hint: "check the broker address and credentials, and that the broker " +
+				"is running",
+			run: func(ctx context.Context) (string, error) {
+				return c.broker(ctx)
+			},
+		})
+	}
+
+	outcomes := map[string]result{}
+	failed := 0
+	for _, ch := range checks {
+		if dep := ch.dependsOn; dep != "" && outcomes[dep] != pass {
+			outcomes[ch.name] = skip
+			fmt.Fprintf(c.out, "[%s] %s: requires %s\n", skip, ch.name, dep)
+			continue
+		}
+
+		detail, err := ch.run(ctx)
+		if err != nil {
+			outcomes[ch.name] = fail
+			failed++
+			fmt.Fprintf(c.out, "[%s] %s: %v\n", fail, ch.name, err)
+			fmt.Fprintf(c.out, "       hint: %s\n", ch.hint)
+			continue
+		}
+		outcomes[ch.name] = pass
+		fmt.Fprintf(c.out, "[%s] %s: %s\n", pass, ch.name, detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func (c *config) discover(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	found := map[string]philips.Info{}
+	err := philips.Discover(ctx, c.discoveryAddr, func(address string, info philips.Info) {
+		mu.Lock()
+		defer mu.Unlock()
+		found[address] = info
+	})
+	if err != nil {
+		return "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(found) == 0 {
+		return "", errors.New("no devices responded")
+	}
+	for address, info := range found {
+		if philips.SameAddress(address, c.host) {
+			return fmt.Sprintf("found %d device(s), including %s at %s", len(found), info.Name, c.host), nil
+		}
+	}
+	return fmt.Sprintf("found %d device(s), but none at %s", len(found), c.host), nil
+}
+
+func (c *config) ping(ctx context.Context) (string, error) {
+	opts := c.opts()
+	conn, err := philips.Dial(ctx, c.host, opts)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.RequestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := conn.PingWithContext(ctx); err != nil {
+		return "", fmt.Errorf("no reply to ping: %w", err)
+	}
+	return fmt.Sprintf("device replied in %s", time.Since(start)), nil
+}
+
+func (c *config) observe(ctx context.Context, dev *philips.Device) (string, error) {
+	received := make(chan error, 1)
+	start := time.Now()
+
+	obs, err := dev.Status(func(req *coap.Request) {
+		_ = philips.Acknowledge(req)
+		_, err := philips.DecodeStatus(req.Msg.Payload())
+		select {
+		case received <- err:
+		default:
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	defer obs.Cancel()
+
+	select {
+	case err := <-received:
+		if err != nil {
+			return "", fmt.Errorf("received a notification but could not read it: %w", err)
+		}
+		return fmt.Sprintf("received a notification after %s", time.Since(start)), nil
+	case <-time.After(c.observeTimeout):
+		return "", fmt.Errorf("no notification within %s", c.observeTimeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (c *config) broker(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cfg, err := c.mqttcfg()
+	if err != nil {
+		return "", err
+	}
+	tr, err := mqtt.New(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	// Start blocks for as long as the connection is up, so if it hasn't
+	// returned after a few seconds we consider the broker reachable
+	done := make(chan error, 1)
+	go func() {
+		_, err := tr.Start()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		return "", err
+	case <-time.After(5 * time.Second):
+		return fmt.Sprintf("connected to %s", cfg.Address), nil
+	}
+}
@@ -0,0 +1,74 @@
+package dumperrors
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/bridge"
+)
+
+type config struct {
+	out     io.Writer
+	url     string
+	timeout time.Duration
+}
+
+// NewCmd returns the dump-errors subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat dump-errors", flag.ExitOnError)
+	fs.StringVar(&c.url, "url", "http://localhost:6060/debug/decode-failures", "decode failures endpoint of publish, served on -debug-listen")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "how long to wait for the endpoint")
+
+	return &ffcli.Command{
+		Name:       "dump-errors",
+		ShortUsage: "dump-errors [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Dump-errors prints the notifications publish couldn't decode",
+		LongHelp: "The dump-errors command gets the last notifications a " +
+			"running publish couldn't decode or parse, and prints them as " +
+			"JSON. Attach the output to a bug report so the problem can be " +
+			"reproduced. publish has to be run with -debug-listen.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get decode failures: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get decode failures: %s", resp.Status)
+	}
+
+	var failures []bridge.DecodeFailure
+	if err := json.NewDecoder(resp.Body).Decode(&failures); err != nil {
+		return fmt.Errorf("failed to parse decode failures: %w", err)
+	}
+	if len(failures) == 0 {
+		fmt.Fprintln(c.out, "no decode failures")
+		return nil
+	}
+
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(failures)
+}
@@ -0,0 +1,58 @@
+// Package exitcode defines the exit codes of the klimat CLI, so scripts can
+// react to specific failures without parsing the output
+package exitcode
+
+import (
+	"errors"
+	"flag"
+
+	"hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/daemon"
+	"hemtjan.st/klimat/logging"
+	"hemtjan.st/klimat/philips"
+)
+
+const (
+	// OK means everything went fine
+	OK = 0
+	// Failure is any error not covered by a more specific code
+	Failure = 1
+	// InvalidArgument means a flag or argument was missing or not valid
+	InvalidArgument = 2
+	// Unreachable means we couldn't talk to the device
+	Unreachable = 3
+	// DecodeError means the device replied with something we couldn't decode
+	DecodeError = 4
+	// Rejected means the device didn't accept a command
+	Rejected = 5
+	// MQTTFailure means the MQTT client couldn't be set up
+	MQTTFailure = 6
+)
+
+// ErrMQTT marks errors caused by the MQTT connection
+var ErrMQTT = errors.New("mqtt failure")
+
+// FromError returns the exit code for an error returned by a subcommand
+func FromError(err error) int {
+	switch {
+	case err == nil:
+		return OK
+	case errors.Is(err, flag.ErrHelp),
+		errors.Is(err, logging.ErrInvalidFlag),
+		errors.Is(err, philips.ErrInvalidOption),
+		errors.Is(err, config.ErrUnknownDevice),
+		errors.Is(err, config.ErrNotAllowed),
+		errors.Is(err, daemon.ErrUnknownDevice):
+		return InvalidArgument
+	case errors.Is(err, philips.ErrUnreachable):
+		return Unreachable
+	case errors.Is(err, philips.ErrDecode):
+		return DecodeError
+	case errors.Is(err, philips.ErrRejected):
+		return Rejected
+	case errors.Is(err, ErrMQTT):
+		return MQTTFailure
+	default:
+		return Failure
+	}
+}
@@ -0,0 +1,163 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+)
+
+// Header are the columns written by Row
+var Header = []string{
+	"time",
+	"power",
+	"mode",
+	"function",
+	"fan_speed",
+	"pm25",
+	"air_quality",
+	"relative_humidity",
+	"relative_humidity_target",
+	"temperature",
+	"water_level",
+	"error",
+}
+
+type config struct {
+	opts     func() philips.Options
+	out      io.Writer
+	host     string
+	duration time.Duration
+	file     string
+	cfgFile  string
+	device   string
+}
+
+// NewCmd returns the export subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat export", flag.ExitOnError)
+	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	c.opts = philips.OptionsFlags(fs)
+	fs.DurationVar(&c.duration, "duration", 0, "how long to record for, 0 records until interrupted")
+	fs.StringVar(&c.file, "out", "", "file to write to, defaults to stdout")
+
+	return &ffcli.Command{
+		Name:       "export",
+		ShortUsage: "export [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Export observes the device and writes the readings as CSV",
+		LongHelp: "The export command observes the device and writes a " +
+			"timestamped row of sensor values for every status update it " +
+			"receives. If the output file already exists new rows are " +
+			"appended to it.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
+	if err != nil {
+		return err
+	}
+	c.host = host
+
+	out := c.out
+	writeHeader := true
+	if c.file != "" {
+		f, err := os.OpenFile(c.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", c.file, err)
+		}
+		defer f.Close()
+		if st, err := f.Stat(); err == nil && st.Size() > 0 {
+			writeHeader = false
+		}
+		out = f
+	}
+
+	if c.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.duration)
+		defer cancel()
+	}
+
+	cl, err := philips.NewWithOptions(ctx, c.host, c.opts())
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	w := csv.NewWriter(out)
+	if writeHeader {
+		if err := w.Write(Header); err != nil {
+			return err
+		}
+		w.Flush()
+	}
+
+	obs, err := cl.Status(func(req *coap.Request) {
+		if err := philips.Acknowledge(req); err != nil {
+			slog.Warn("failed to acknowledge message", "err", err)
+		}
+
+		data, err := philips.DecodeStatus(req.Msg.Payload())
+		if err != nil {
+			slog.Warn("failed to decode status", "err", err, "payload", string(req.Msg.Payload()))
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err := w.Write(Row(time.Now(), data.State.Reported)); err != nil {
+			slog.Error("failed to write row", "err", err)
+			return
+		}
+		w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	obs.Cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	w.Flush()
+	return w.Error()
+}
+
+// Row returns the CSV columns for a status update received at t
+func Row(t time.Time, r *philips.Reported) []string {
+	return []string{
+		t.Format(time.RFC3339),
+		string(r.Power),
+		string(r.Mode),
+		string(r.Function),
+		string(r.FanSpeed),
+		strconv.Itoa(r.ParticulateMatter25),
+		strconv.Itoa(int(r.AirQuality)),
+		strconv.Itoa(r.RelativeHumidity),
+		strconv.Itoa(r.RelativeHumidityTarget),
+		strconv.Itoa(r.Temperature),
+		strconv.Itoa(r.WaterLevel),
+		strconv.Itoa(int(r.Err)),
+	}
+}
@@ -0,0 +1,111 @@
+package healthcheck
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+type config struct {
+	out      io.Writer
+	url      string
+	stateDir string
+	maxAge   time.Duration
+	timeout  time.Duration
+}
+
+// NewCmd returns the healthcheck subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat healthcheck", flag.ExitOnError)
+	fs.StringVar(&c.url, "url", "http://localhost:9090/healthz", "health endpoint of publish, served on -metrics-listen")
+	fs.StringVar(&c.stateDir, "state-dir", "", "check the state files publish writes with -state-dir instead of the health endpoint")
+	fs.DurationVar(&c.maxAge, "max-age", 10*time.Minute, "how old the newest state file can be with -state-dir")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "how long to wait for the health endpoint")
+
+	return &ffcli.Command{
+		Name:       "healthcheck",
+		ShortUsage: "healthcheck [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Healthcheck checks if publish is healthy",
+		LongHelp: "The healthcheck command asks the health endpoint of a " +
+			"running publish if it's healthy, and exits with 0 if it is and 1 " +
+			"if it isn't. With -state-dir it instead checks that publish " +
+			"wrote a state file recently. It's meant to be used as a Docker " +
+			"HEALTHCHECK or Kubernetes exec probe.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	var err error
+	if c.stateDir != "" {
+		err = c.checkStateDir()
+	} else {
+		err = c.checkURL(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("unhealthy: %w", err)
+	}
+	fmt.Fprintln(c.out, "healthy")
+	return nil
+}
+
+func (c *config) checkURL(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// checkStateDir checks that at least one state file was written within
+// max-age. They're written on every notification from a device
+func (c *config) checkStateDir() error {
+	files, err := filepath.Glob(filepath.Join(c.stateDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var newest time.Time
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if st.ModTime().After(newest) {
+			newest = st.ModTime()
+		}
+	}
+	if newest.IsZero() {
+		return fmt.Errorf("no state files in %s", c.stateDir)
+	}
+	if age := time.Since(newest); age > c.maxAge {
+		return fmt.Errorf("newest state file is %s old", age.Round(time.Second))
+	}
+	return nil
+}
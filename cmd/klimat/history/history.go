@@ -0,0 +1,131 @@
+package history
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/cmd/klimat/export"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/history"
+)
+
+type config struct {
+	out     io.Writer
+	cfgFile string
+	dir     string
+	device  string
+	from    string
+	to      string
+	format  string
+}
+
+// NewCmd returns the history subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat history query", flag.ExitOnError)
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.dir, "history-dir", "", "directory the history is kept in, defaults to next to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID of the device")
+	fs.StringVar(&c.from, "from", "24h", "start of the readings, as a time in RFC 3339 or a duration ago")
+	fs.StringVar(&c.to, "to", "", "end of the readings, as a time in RFC 3339 or a duration ago. Empty means now")
+	fs.StringVar(&c.format, "format", "csv", "output format: csv or json")
+
+	query := &ffcli.Command{
+		Name:       "query",
+		ShortUsage: "history query -device <device> [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Query prints the readings of a device from its history",
+		LongHelp: "The query command prints the readings publish stored " +
+			"with -history between -from and -to, as CSV like export writes " +
+			"or as JSON lines.",
+		Exec: c.Exec,
+	}
+
+	return &ffcli.Command{
+		Name:        "history",
+		ShortUsage:  "history <subcommand>",
+		ShortHelp:   "History gives access to the readings publish stored",
+		FlagSet:     flag.NewFlagSet("klimat history", flag.ExitOnError),
+		Subcommands: []*ffcli.Command{query},
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	if c.device == "" {
+		return flag.ErrHelp
+	}
+	now := time.Now()
+	from, err := parseTime(c.from, now)
+	if err != nil {
+		return err
+	}
+	to := now
+	if c.to != "" {
+		if to, err = parseTime(c.to, now); err != nil {
+			return err
+		}
+	}
+
+	id := c.device
+	conf, err := klimatcfg.Load(c.cfgFile)
+	if err != nil {
+		return err
+	}
+	if _, d, err := conf.Lookup(c.device); err == nil && d.DeviceID != "" {
+		id = d.DeviceID
+	}
+
+	dir := c.dir
+	if dir == "" {
+		dir = klimatcfg.HistoryPath(c.cfgFile)
+	}
+	samples, err := history.New(dir, 0).Query(id, from, to)
+	if err != nil {
+		return err
+	}
+
+	switch c.format {
+	case "json":
+		enc := json.NewEncoder(c.out)
+		for _, s := range samples {
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		w := csv.NewWriter(c.out)
+		w.Write(export.Header)
+		for _, s := range samples {
+			w.Write(export.Row(s.Time, s.State))
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return fmt.Errorf("unknown format %q, use csv or json", c.format)
+	}
+}
+
+// parseTime parses an RFC 3339 time, or a duration before now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or a duration like 24h", s)
+	}
+	return t, nil
+}
@@ -5,15 +5,31 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 
+	"hemtjan.st/klimat/cmd/klimat/configure"
 	"hemtjan.st/klimat/cmd/klimat/control"
+	"hemtjan.st/klimat/cmd/klimat/daemon"
 	"hemtjan.st/klimat/cmd/klimat/discover"
+	"hemtjan.st/klimat/cmd/klimat/doctor"
+	"hemtjan.st/klimat/cmd/klimat/dumperrors"
+	"hemtjan.st/klimat/cmd/klimat/exitcode"
+	"hemtjan.st/klimat/cmd/klimat/export"
+	"hemtjan.st/klimat/cmd/klimat/healthcheck"
+	"hemtjan.st/klimat/cmd/klimat/history"
+	"hemtjan.st/klimat/cmd/klimat/ping"
+	"hemtjan.st/klimat/cmd/klimat/proxy"
 	"hemtjan.st/klimat/cmd/klimat/publish"
+	"hemtjan.st/klimat/cmd/klimat/record"
+	"hemtjan.st/klimat/cmd/klimat/sniff"
 	"hemtjan.st/klimat/cmd/klimat/status"
+	"hemtjan.st/klimat/cmd/klimat/vacation"
+	"hemtjan.st/klimat/logging"
 )
 
 var (
@@ -32,7 +48,7 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	defer func() {
 		signal.Stop(c)
 		cancel()
@@ -40,7 +56,7 @@ func main() {
 	go func() {
 		select {
 		case <-c:
-			log.Print("Received cancellation signal, shutting down...")
+			slog.Info("received cancellation signal, shutting down")
 			cancel()
 		case <-ctx.Done():
 		}
@@ -53,10 +69,22 @@ func main() {
 			"devices.",
 		FlagSet: rootFlagset,
 		Subcommands: []*ffcli.Command{
+			configure.NewCmd(os.Stdout),
 			control.NewCmd(os.Stdout),
+			daemon.NewCmd(os.Stdout),
 			discover.NewCmd(os.Stdout),
+			doctor.NewCmd(os.Stdout),
+			dumperrors.NewCmd(os.Stdout),
+			export.NewCmd(os.Stdout),
+			healthcheck.NewCmd(os.Stdout),
+			history.NewCmd(os.Stdout),
+			ping.NewCmd(os.Stdout),
+			proxy.NewCmd(os.Stdout),
 			publish.NewCmd(os.Stdout),
+			record.NewCmd(os.Stdout),
+			sniff.NewCmd(os.Stdout),
 			status.NewCmd(os.Stdout),
+			vacation.NewCmd(os.Stdout),
 		},
 		Exec: func(context.Context, []string) error {
 			if fversion {
@@ -67,8 +95,31 @@ func main() {
 		},
 	}
 
+	// Every subcommand gets the logging flags, and sets up logging before
+	// it or any of its own subcommands run
+	for _, cmd := range root.Subcommands {
+		withLogging(cmd, logging.Flags(cmd.FlagSet))
+	}
+
 	if err := root.ParseAndRun(ctx, os.Args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitcode.FromError(err))
+	}
+}
+
+// withLogging makes cmd and its subcommands call setup before they run.
+// ffcli only runs the Exec of the subcommand that was picked, so every one
+// of them needs it
+func withLogging(cmd *ffcli.Command, setup func() error) {
+	if exec := cmd.Exec; exec != nil {
+		cmd.Exec = func(ctx context.Context, args []string) error {
+			if err := setup(); err != nil {
+				return err
+			}
+			return exec(ctx, args)
+		}
+	}
+	for _, sub := range cmd.Subcommands {
+		withLogging(sub, setup)
 	}
 }
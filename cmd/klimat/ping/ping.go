@@ -0,0 +1,172 @@
+package ping
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+)
+
+type config struct {
+	out      io.Writer
+	host     string
+	opts     func() philips.Options
+	count    int
+	interval time.Duration
+	get      bool
+	cfgFile  string
+	device   string
+}
+
+// NewCmd returns the ping subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat ping", flag.ExitOnError)
+	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	c.opts = philips.OptionsFlags(fs)
+	fs.IntVar(&c.count, "count", 5, "number of pings to send")
+	fs.DurationVar(&c.interval, "interval", 1*time.Second, "time to wait between pings")
+	fs.BoolVar(&c.get, "get", false, "also GET /sys/dev/info to check the device answers requests")
+
+	return &ffcli.Command{
+		Name:       "ping",
+		ShortUsage: "ping [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Ping checks if a device is reachable",
+		LongHelp: "The ping command sends CoAP pings to a device and reports " +
+			"the round trip time and packet loss. It does not set up a session " +
+			"with the device, so it can be used to tell network problems apart " +
+			"from protocol problems. With -get it additionally requests " +
+			"/sys/dev/info for every ping.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
+	if err != nil {
+		return err
+	}
+	c.host = host
+
+	if c.count < 1 {
+		return flag.ErrHelp
+	}
+
+	opts := c.opts()
+	conn, err := philips.Dial(ctx, c.host, opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	pings := stats{}
+	gets := stats{}
+
+	for i := 0; i < c.count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(c.interval):
+			case <-ctx.Done():
+				c.summary(pings, gets)
+				return nil
+			}
+		}
+
+		rtt, err := do(ctx, opts.RequestTimeout, func(ctx context.Context) error {
+			return conn.PingWithContext(ctx)
+		})
+		pings.record(rtt, err)
+		if err != nil {
+			fmt.Fprintf(c.out, "ping %d to %s: %v\n", i+1, c.host, err)
+		} else {
+			fmt.Fprintf(c.out, "ping %d to %s: time=%s\n", i+1, c.host, rtt)
+		}
+
+		if !c.get {
+			continue
+		}
+
+		rtt, err = do(ctx, opts.RequestTimeout, func(ctx context.Context) error {
+			_, err := conn.GetWithContext(ctx, "/sys/dev/info")
+			return err
+		})
+		gets.record(rtt, err)
+		if err != nil {
+			fmt.Fprintf(c.out, "get %d to %s: %v\n", i+1, c.host, err)
+		} else {
+			fmt.Fprintf(c.out, "get %d to %s: time=%s\n", i+1, c.host, rtt)
+		}
+	}
+
+	c.summary(pings, gets)
+	if pings.received == 0 {
+		return fmt.Errorf("%w: no replies from %s", philips.ErrUnreachable, c.host)
+	}
+	return nil
+}
+
+func do(ctx context.Context, timeout time.Duration, fn func(context.Context) error) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	return time.Since(start), err
+}
+
+func (c *config) summary(pings, gets stats) {
+	fmt.Fprintf(c.out, "\n--- %s ping statistics ---\n", c.host)
+	fmt.Fprintln(c.out, pings.String())
+	if c.get {
+		fmt.Fprintf(c.out, "--- %s get statistics ---\n", c.host)
+		fmt.Fprintln(c.out, gets.String())
+	}
+}
+
+// stats keeps track of the results of a series of requests
+type stats struct {
+	sent     int
+	received int
+	min      time.Duration
+	max      time.Duration
+	total    time.Duration
+}
+
+func (s *stats) record(rtt time.Duration, err error) {
+	s.sent++
+	if err != nil {
+		return
+	}
+	s.received++
+	s.total += rtt
+	if s.min == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+}
+
+func (s stats) String() string {
+	if s.sent == 0 {
+		return "no requests sent"
+	}
+	loss := float64(s.sent-s.received) / float64(s.sent) * 100
+	res := fmt.Sprintf("%d sent, %d received, %.1f%% loss", s.sent, s.received, loss)
+	if s.received > 0 {
+		avg := s.total / time.Duration(s.received)
+		res += fmt.Sprintf("\nrtt min/avg/max = %s/%s/%s", s.min, avg, s.max)
+	}
+	return res
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/bridge"
+	"hemtjan.st/klimat/cmd/klimat/broker"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+	"hemtjan.st/klimat/proxy"
+	"lib.hemtjan.st/transport/mqtt"
+)
+
+type config struct {
+	opts    func() philips.Options
+	out     io.Writer
+	mqttcfg func() (*mqtt.Config, error)
+	host    string
+	cfgFile string
+	device  string
+	listen  string
+	topic   string
+	debug   bool
+}
+
+// NewCmd returns the proxy subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat proxy", flag.ExitOnError)
+	c.mqttcfg = broker.Flags(fs)
+	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	c.opts = philips.OptionsFlags(fs)
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	fs.StringVar(&c.listen, "listen", ":5683", "address to serve the device to the app on")
+	fs.StringVar(&c.topic, "topic", bridge.DefaultTopic, "MQTT topic for the device, like publish takes it")
+	fs.BoolVar(&c.debug, "debug", false, "enable debug output")
+
+	return &ffcli.Command{
+		Name:       "proxy",
+		ShortUsage: "proxy [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Proxy shares a device between the vendor app and MQTT",
+		LongHelp: "The proxy command bridges a device to MQTT like publish " +
+			"does, and serves it over CoAP on -listen to the Air Matters or " +
+			"Clean Home+ app. The app gets a session with the proxy, and its " +
+			"commands are decrypted and sent on over the one session the " +
+			"proxy has with the device, so the app and the bridge don't " +
+			"trip each other up. The app has to be pointed at the proxy " +
+			"instead of the device.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	opts := bridge.Options{
+		DeviceOptions: c.opts(),
+		Topic:         c.topic,
+		Debug:         c.debug,
+	}
+	if c.device != "" {
+		conf, err := klimatcfg.Load(c.cfgFile)
+		if err != nil {
+			return err
+		}
+		if alias, d, err := conf.Lookup(c.device); err == nil {
+			opts.Alias = alias
+			if d.Topic != "" {
+				opts.Topic = d.Topic
+			}
+			if opts.DeviceOptions, err = philips.ParseOptions(opts.DeviceOptions, d.Options); err != nil {
+				return err
+			}
+		}
+	}
+	var err error
+	opts.AirQuality, err = bridge.ParseAirQualityMapping("")
+	if err != nil {
+		return err
+	}
+
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
+	if err != nil {
+		return err
+	}
+	cl, err := philips.NewWithOptions(ctx, host, opts.DeviceOptions)
+	if err != nil {
+		return err
+	}
+	info, err := cl.Info()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := c.mqttcfg()
+	if err != nil {
+		return err
+	}
+	// Like publish, the MQTT connection outlives the bridge so it can
+	// announce the device going away
+	mqctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	mq, err := broker.Connect(mqctx, cfg)
+	if err != nil {
+		return err
+	}
+	opts.LastWillID = cfg.ClientID
+
+	var b *bridge.Bridge
+	px := proxy.New(proxy.Options{
+		Address: c.listen,
+		Info:    info,
+		Control: func(desired []byte) error {
+			return b.SetRaw(desired)
+		},
+	})
+	opts.OnRaw = px.Notify
+	b, err = bridge.NewBridge(cl, mq, opts)
+	if err != nil {
+		return err
+	}
+	mq.OnReconnect(b.Refresh)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	perr := make(chan error, 1)
+	go func() {
+		err := px.Run(ctx)
+		// Without the proxy there's no point in carrying on
+		cancel()
+		perr <- err
+	}()
+
+	slog.Info("done initialising, publishing updates to MQTT", "topic", b.Topic(), "broker", cfg.Address)
+	err = b.Run(ctx)
+	cancel()
+	if pe := <-perr; pe != nil {
+		err = pe
+	}
+	// Give the client a moment to send what the bridge published on its
+	// way out before disconnecting
+	time.Sleep(time.Second)
+	return err
+}
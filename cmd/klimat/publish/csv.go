@@ -0,0 +1,43 @@
+package publish
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/cmd/klimat/export"
+	"hemtjan.st/klimat/philips"
+)
+
+// csvSink appends status updates to a CSV file per device and day, with
+// the same columns as export
+type csvSink struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func (s *csvSink) add(deviceID string, t time.Time, r *philips.Reported) error {
+	path := filepath.Join(s.dir, deviceID, t.UTC().Format("2006-01-02")+".csv")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create CSV directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		w.Write(export.Header)
+	}
+	w.Write(export.Row(t, r))
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,57 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"hemtjan.st/klimat/bridge"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/monitor"
+	"lib.hemtjan.st/device"
+)
+
+// monitors starts publishing the air quality monitors from -monitors on
+// tr, in the background until the context is cancelled
+func (c *config) monitors(ctx context.Context, tr device.Transport, lastWillID string) error {
+	names := splitList(c.monitorNames)
+	if len(names) == 0 {
+		return nil
+	}
+
+	conf, err := klimatcfg.Load(c.cfgFile)
+	if err != nil {
+		return err
+	}
+	if len(names) == 1 && names[0] == "all" {
+		names = conf.MonitorAliases()
+	}
+
+	aq, err := bridge.ParseAirQualityMapping(c.airQuality)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		m, ok := conf.Monitors[name]
+		if !ok {
+			return fmt.Errorf("%w: no monitor %s", klimatcfg.ErrUnknownDevice, name)
+		}
+		src, err := monitor.New(m.Driver, m.Address)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		opts := monitor.Options{
+			Topic:      orDefault(m.Topic, "climate/monitor/"+name),
+			Name:       orDefault(m.Name, name),
+			Interval:   c.monitorInterval,
+			AirQuality: aq,
+			LastWillID: lastWillID,
+		}
+		go func(name string) {
+			if err := monitor.Run(ctx, src, tr, opts); err != nil {
+				slog.Error("failed to publish monitor", "subsystem", "monitor", "monitor", name, "err", err)
+			}
+		}(name)
+	}
+	return nil
+}
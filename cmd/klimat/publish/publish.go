@@ -6,37 +6,99 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
-	"math"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-ocf/go-coap"
-	"github.com/go-ocf/go-coap/codes"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/aqi"
+	"hemtjan.st/klimat/automation"
+	"hemtjan.st/klimat/bridge"
+	"hemtjan.st/klimat/cmd/klimat/broker"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/daemon"
+	"hemtjan.st/klimat/driver"
+	"hemtjan.st/klimat/fleet"
+	"hemtjan.st/klimat/history"
+	"hemtjan.st/klimat/influx"
+	"hemtjan.st/klimat/metrics"
 	"hemtjan.st/klimat/philips"
-	"lib.hemtjan.st/client"
-	"lib.hemtjan.st/device"
-	"lib.hemtjan.st/feature"
+	"hemtjan.st/klimat/stream"
+	"hemtjan.st/klimat/webhook"
 	"lib.hemtjan.st/transport/mqtt"
 )
 
-const (
-	twoWeeks = 336 // hours
-)
-
 type config struct {
+	opts    func() philips.Options
 	out     io.Writer
 	host    string
-	mqttcfg func() *mqtt.Config
+	mqttcfg func() (*mqtt.Config, error)
 	debug   bool
+	replay  string
+	cfgFile string
+	device  string
+	devices string
+
+	maxDials     int
+	stagger      time.Duration
+	debugDevices string
+
+	monitorNames    string
+	monitorInterval time.Duration
+
+	rediscover    time.Duration
+	discoveryAddr string
+	fullRefresh   time.Duration
+	topic         string
+	staleAfter    time.Duration
+	refresh       time.Duration
+	observeWait   time.Duration
+	pollInterval  time.Duration
+	stateDir      string
+	clearOnExit   bool
+	fanRamp       time.Duration
+	presence      string
+	noRetain      string
+	haDiscovery   bool
+	haPrefix      string
+	homie         string
+	features      string
+	pm25Max       int
+	airQuality    string
+	aqi           string
+	smoothAlpha   float64
+	smoothWindow  int
+	webhookURL    string
+	webhookBody   string
+	influxURL     string
+	influxOrg     string
+	influxBucket  string
+	influxToken   string
+	history       bool
+	historyDir    string
+	retention     time.Duration
+	csvDir        string
+	metricsListen string
+	debugListen   string
+	socket        string
+	failures      int
+	name          string
+	manufacturer  string
+	model         string
+	typ           string
+	split         bool
+	light         bool
+	aqSensor      bool
+	tempSensor    bool
+	humSensor     bool
 }
 
 // NewCmd returns the publish subcommand
 func NewCmd(out io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("klimat publish", flag.ExitOnError)
-	mqCfg := mqtt.MustFlags(fs.String, fs.Bool)
+	mqCfg := broker.Flags(fs)
 
 	c := config{
 		out:     out,
@@ -45,7 +107,62 @@ func NewCmd(out io.Writer) *ffcli.Command {
 	}
 
 	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	c.opts = philips.OptionsFlags(fs)
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	fs.StringVar(&c.devices, "devices", "", "comma separated list of aliases or DeviceIDs from the configuration file to publish at once, or all for every configured device")
+	fs.IntVar(&c.maxDials, "max-dials", 4, "how many devices to connect to at the same time with -devices, 0 means no limit")
+	fs.DurationVar(&c.stagger, "stagger", 2*time.Second, "how long to wait between starting on one device and the next with -devices")
+	fs.StringVar(&c.monitorNames, "monitors", "", "comma separated list of air quality monitors from the configuration file to publish too, or all for every configured monitor")
+	fs.DurationVar(&c.monitorInterval, "monitor-interval", time.Minute, "how often to read the monitors from -monitors")
 	fs.BoolVar(&c.debug, "debug", false, "enable debug output")
+	fs.StringVar(&c.debugDevices, "debug-devices", "", "comma separated list of aliases or DeviceIDs to enable debug output for with -devices, instead of all of them with -debug")
+	fs.DurationVar(&c.rediscover, "rediscover", 0, "look for the device on the network this often and reconnect if its address changed, 0 disables it")
+	fs.StringVar(&c.discoveryAddr, "discovery-address", philips.DiscoveryAddress, "host:port for multicast discovery used by -rediscover")
+	fs.DurationVar(&c.fullRefresh, "full-refresh", 10*time.Minute, "publish every value this often even if it didn't change, 0 publishes everything on every update")
+	fs.StringVar(&c.topic, "topic", bridge.DefaultTopic, "MQTT topic for the device, {id}, {alias}, {name} and {model} are replaced with the DeviceID, alias from -device, name and model")
+	fs.DurationVar(&c.refresh, "refresh", 0, "also request the status this often and publish everything, to recover from observations that silently died. 0 disables it")
+	fs.StringVar(&c.stateDir, "state-dir", "", "directory to keep the last status of the device in, to publish it on startup until the device reports")
+	fs.BoolVar(&c.clearOnExit, "clear-on-exit", false, "remove the retained values of all features when shutting down")
+	fs.DurationVar(&c.fanRamp, "fan-ramp", 0, "when the fan speed changes by more than one step, go through the speeds in between this far apart. 0 changes it right away")
+	fs.StringVar(&c.presence, "presence-topic", "", "MQTT topic that ends vacation mode when anything is published to it, like when someone comes home")
+	fs.DurationVar(&c.observeWait, "observe-timeout", 30*time.Second, "poll the status instead of observing it if the device sends no notification this long after observing started, 0 disables it")
+	fs.DurationVar(&c.pollInterval, "poll-interval", 15*time.Second, "how often to poll the status when observing doesn't work")
+	fs.DurationVar(&c.staleAfter, "stale-after", 5*time.Minute, "mark the device offline if it didn't send anything for this long, 0 disables it")
+	fs.StringVar(&c.noRetain, "no-retain", "", "comma separated list of features to publish without the retain flag")
+	fs.BoolVar(&c.haDiscovery, "ha-discovery", false, "also publish Home Assistant MQTT discovery configs")
+	fs.StringVar(&c.haPrefix, "ha-prefix", "homeassistant", "Home Assistant discovery prefix for -ha-discovery")
+	fs.StringVar(&c.homie, "homie", "", "also publish the device following the Homie convention under this base topic, usually homie")
+	fs.StringVar(&c.name, "name", "", "name to announce the device with instead of the one it reports")
+	fs.StringVar(&c.manufacturer, "manufacturer", "", "manufacturer to announce the device with instead of Philips")
+	fs.StringVar(&c.model, "model", "", "model to announce the device with instead of the one it reports")
+	fs.StringVar(&c.typ, "type", "", "Hemtjänst type to announce the device with instead of airPurifier")
+	fs.IntVar(&c.pm25Max, "pm25-max", 0, "cap pm2_5Density at this value, 0 publishes the value as reported")
+	fs.StringVar(&c.airQuality, "air-quality", "", "how air quality is mapped onto 1 to 5: iai, pm25, epa, or a source and 4 thresholds like pm25:10,20,30,40 (default iai)")
+	fs.StringVar(&c.aqi, "aqi", "", "publish an air quality index computed from PM2.5 as the aqi feature: epa or china, empty disables it")
+	fs.Float64Var(&c.smoothAlpha, "smoothing-alpha", 0, "smooth PM2.5, humidity and temperature with an exponential moving average, between 0 and 1 where lower smooths more. 0 disables it")
+	fs.IntVar(&c.smoothWindow, "smoothing-window", 0, "smooth PM2.5, humidity and temperature with a moving average over this many readings instead. 0 disables it")
+	fs.StringVar(&c.features, "features", "", "comma separated list of features to announce, by default they're picked based on the model")
+	fs.BoolVar(&c.split, "split", false, "publish the humidifier as a separate humidifierDehumidifier device instead of combining it with the purifier")
+	fs.BoolVar(&c.light, "light", false, "also publish the light ring as a dimmable lightbulb device")
+	fs.BoolVar(&c.aqSensor, "air-quality-sensor", false, "also publish the air quality readings as a separate airQualitySensor device")
+	fs.BoolVar(&c.tempSensor, "temperature-sensor", false, "also publish the temperature as a separate temperatureSensor device")
+	fs.BoolVar(&c.humSensor, "humidity-sensor", false, "also publish the relative humidity as a separate humiditySensor device")
+	fs.StringVar(&c.webhookURL, "webhook-url", "", "URL to post alerts to, a text/template that gets the alert")
+	fs.StringVar(&c.webhookBody, "webhook-body", webhook.DefaultBody, "body to post to -webhook-url, a text/template that gets the alert")
+	fs.StringVar(&c.influxURL, "influx-url", "", "InfluxDB to write every status update to, like http://localhost:8086. Empty disables it")
+	fs.StringVar(&c.influxOrg, "influx-org", "", "InfluxDB organization for -influx-url")
+	fs.StringVar(&c.influxBucket, "influx-bucket", "klimat", "InfluxDB bucket for -influx-url")
+	fs.StringVar(&c.influxToken, "influx-token", "", "InfluxDB API token for -influx-url")
+	fs.BoolVar(&c.history, "history", false, "keep every status update on disk, to look up with klimat history query")
+	fs.StringVar(&c.historyDir, "history-dir", "", "directory to keep the history in with -history, defaults to next to the configuration file")
+	fs.DurationVar(&c.retention, "history-retention", 30*24*time.Hour, "how long to keep the history for with -history, 0 keeps it forever")
+	fs.StringVar(&c.csvDir, "csv-dir", "", "directory to write every status update to, as a CSV file per device and day. Empty disables it")
+	fs.StringVar(&c.metricsListen, "metrics-listen", "", "address to serve Prometheus metrics on at /metrics, like :9090. Empty disables it")
+	fs.StringVar(&c.debugListen, "debug-listen", "", "address to serve pprof and expvar on at /debug, like localhost:6060. Empty disables it")
+	fs.IntVar(&c.failures, "decode-failures", 20, "how many notifications that couldn't be decoded to keep for /debug/decode-failures on -debug-listen")
+	fs.StringVar(&c.replay, "replay", "", "publish the notifications from a recording instead of a device")
+	fs.StringVar(&c.socket, "socket", "", "unix socket to serve the control API on, for klimat daemon and control. Empty disables it")
 
 	return &ffcli.Command{
 		Name:       "publish",
@@ -53,177 +170,414 @@ func NewCmd(out io.Writer) *ffcli.Command {
 		ShortHelp:  "Publish sensor data to MQTT",
 		LongHelp: "The publish command connects to a device over CoAP and " +
 			"starts to observe it. As it receives updates the device state and " +
-			"sensor data is extracted and published to MQTT.",
+			"sensor data is extracted and published to MQTT. If the device stops " +
+			"responding, for example because it rebooted, we reconnect to it. " +
+			"With -rediscover " +
+			"the device is looked up by its DeviceID on an interval, and if its " +
+			"address changed we transparently reconnect to it.",
 		FlagSet: fs,
 		Exec:    c.Exec,
 	}
 }
 
 func (c *config) Exec(ctx context.Context, args []string) error {
-	cl, err := philips.New(ctx, c.host)
-	if err != nil {
-		return err
+	opts := bridge.Options{
+		DeviceOptions:     c.opts(),
+		Rediscover:        c.rediscover,
+		DiscoveryAddress:  c.discoveryAddr,
+		RegistryPath:      klimatcfg.RegistryPath(c.cfgFile),
+		FullRefresh:       c.fullRefresh,
+		Topic:             c.topic,
+		StaleAfter:        c.staleAfter,
+		Refresh:           c.refresh,
+		ObserveTimeout:    c.observeWait,
+		PollInterval:      c.pollInterval,
+		StateDir:          c.stateDir,
+		ClearOnExit:       c.clearOnExit,
+		FanRamp:           c.fanRamp,
+		PresenceTopic:     c.presence,
+		NoRetain:          splitList(c.noRetain),
+		Homie:             c.homie,
+		Name:              c.name,
+		Manufacturer:      c.manufacturer,
+		Model:             c.model,
+		Type:              c.typ,
+		Features:          splitList(c.features),
+		PM25Max:           c.pm25Max,
+		SmoothingAlpha:    c.smoothAlpha,
+		SmoothingWindow:   c.smoothWindow,
+		Split:             c.split,
+		Light:             c.light,
+		AirQualitySensor:  c.aqSensor,
+		TemperatureSensor: c.tempSensor,
+		HumiditySensor:    c.humSensor,
+		Debug:             c.debug,
+	}
+	if c.haDiscovery {
+		opts.HomeAssistant = c.haPrefix
 	}
+	var (
+		cl   *philips.Device
+		recs []philips.Recording
+		err  error
+	)
 
-	info, err := cl.Info()
+	conf, err := klimatcfg.Load(c.cfgFile)
 	if err != nil {
 		return err
 	}
+	opts.Allowed = conf.Allowed
 
-	cfg := c.mqttcfg()
-	mq := connectMqtt(ctx, cfg)
-	dev, err := client.NewDevice(&device.Info{
-		Topic:        fmt.Sprintf("climate/%s", info.DeviceID),
-		Name:         info.Name,
-		Manufacturer: "Philips",
-		Model:        info.ModelID,
-		SerialNumber: info.DeviceID,
-		Type:         "airPurifier",
-		Features: map[string]*feature.Info{
-			"on":                                 {},
-			"brightness":                         {},
-			"currentAirPurifierState":            {},
-			"targetAirPurifierState":             {},
-			"currentFanState":                    {},
-			"targetFanState":                     {},
-			"rotationSpeed":                      {},
-			"lockPhysicalControls":               {},
-			"airQuality":                         {},
-			"pm2_5Density":                       {},
-			"filterChangeIndication":             {},
-			"currentRelativeHumidity":            {},
-			"targetRelativeHumidity":             {},
-			"currentHumidifierDehumidifierState": {},
-			"targetHumidifierDehumidifierState":  {},
-			"currentTemperature":                 {},
-			"waterLevel":                         {},
-		},
-	}, mq)
-	if err != nil {
-		return fmt.Errorf("failed to create device: %w", err)
+	if c.aqi != "" {
+		opts.AQI, err = aqi.Lookup(c.aqi)
+		if err != nil {
+			return err
+		}
+	}
+	if c.webhookURL != "" {
+		wh, err := webhook.New(c.webhookURL, c.webhookBody)
+		if err != nil {
+			return err
+		}
+		opts.OnAlert = func(a bridge.Alert) {
+			go func() {
+				if err := wh.Send(ctx, a); err != nil {
+					slog.Warn("failed to send alert", "subsystem", "webhook", "event", a.Event, "err", err)
+				}
+			}()
+		}
 	}
 
-	log.Print("starting observer for status messages")
-	obs, err := cl.Status(handleObserve(dev))
-	if err != nil {
-		return err
+	// mux is what's served on -metrics-listen. /healthz is added once we
+	// know what to check
+	var mux *http.ServeMux
+	// onStatus are the functions that get every status update
+	var onStatus []func(string, *philips.Reported)
+	if c.metricsListen != "" {
+		opts.Metrics = metrics.New()
+		mux = http.NewServeMux()
+		mux.Handle("/metrics", opts.Metrics)
+
+		hub := stream.NewHub()
+		mux.Handle("/devices/", hub)
+		onStatus = append(onStatus, func(id string, state *philips.Reported) {
+			update, err := json.Marshal(state)
+			if err != nil {
+				slog.Error("failed to encode status", "device", id, "err", err)
+				return
+			}
+			hub.Publish(id, update)
+		})
+		if err := listen(ctx, c.metricsListen, mux); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Done initialising, publishing updates to MQTT on: %s", cfg.Address)
+	if c.influxURL != "" {
+		w, err := influx.New(c.influxURL, c.influxOrg, c.influxBucket, c.influxToken)
+		if err != nil {
+			return err
+		}
+		go w.Run(ctx)
+		onStatus = append(onStatus, func(id string, state *philips.Reported) {
+			w.Add(id, time.Now(), state)
+		})
+	}
+	if c.history {
+		dir := c.historyDir
+		if dir == "" {
+			dir = klimatcfg.HistoryPath(c.cfgFile)
+		}
+		store := history.New(dir, c.retention)
+		go store.Run(ctx)
+		onStatus = append(onStatus, func(id string, state *philips.Reported) {
+			if err := store.Add(id, time.Now(), state); err != nil {
+				slog.Warn("failed to store status", "subsystem", "history", "device", id, "err", err)
+			}
+		})
+	}
+	if c.csvDir != "" {
+		sink := &csvSink{dir: c.csvDir}
+		onStatus = append(onStatus, func(id string, state *philips.Reported) {
+			if err := sink.add(id, time.Now(), state); err != nil {
+				slog.Warn("failed to write CSV", "device", id, "err", err)
+			}
+		})
+	}
+	if len(onStatus) > 0 {
+		opts.OnStatus = func(id string, state *philips.Reported) {
+			for _, fn := range onStatus {
+				fn(id, state)
+			}
+		}
+	}
 
-	<-ctx.Done()
-	obs.Cancel()
+	if c.debugListen != "" {
+		opts.Failures = bridge.NewFailures(c.failures)
+		if err := listen(ctx, c.debugListen, debugHandler(opts.Failures)); err != nil {
+			return err
+		}
+	}
 
-	return nil
-}
+	if c.devices != "" {
+		return c.fleet(ctx, opts, mux)
+	}
+	if c.device != "" {
+		if err := c.lookupDevice(c.device, &opts); err != nil {
+			return err
+		}
+	} else {
+		opts.AirQuality, err = bridge.ParseAirQualityMapping(c.airQuality)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.replay != "" {
+		recs, err = readRecording(c.replay)
+		if err != nil {
+			return err
+		}
+	} else {
+		host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
+		if err != nil {
+			return err
+		}
+		cl, err = philips.NewWithOptions(ctx, host, opts.DeviceOptions)
+		if err != nil {
+			return err
+		}
+	}
 
-func connectMqtt(ctx context.Context, config *mqtt.Config) mqtt.MQTT {
-	tr, err := mqtt.New(ctx, config)
+	cfg, err := c.mqttcfg()
+	if err != nil {
+		return err
+	}
+	// The MQTT connection has to outlive the bridge, so it can still
+	// announce that the device is going away when we're shutting down
+	mqctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	mq, err := broker.Connect(mqctx, cfg)
 	if err != nil {
-		log.Fatalf("Error creating MQTT client: %v", err)
+		return err
+	}
+	opts.LastWillID = cfg.ClientID
+	if err := c.monitors(ctx, mq, cfg.ClientID); err != nil {
+		return err
 	}
 
-	go func() {
-		for {
-			ok, err := tr.Start()
-			if !ok {
-				break
+	var b *bridge.Bridge
+	if recs != nil {
+		b, err = bridge.NewReplayBridge(recs, mq, opts)
+	} else {
+		b, err = bridge.NewBridge(cl, mq, opts)
+	}
+	if err != nil {
+		return err
+	}
+	mq.OnReconnect(b.Refresh)
+	if c.socket != "" {
+		srv := daemon.NewServer(func() []*bridge.Bridge {
+			return []*bridge.Bridge{b}
+		})
+		if err := daemon.Listen(ctx, c.socket, srv); err != nil {
+			return err
+		}
+	}
+	if mux != nil {
+		mux.Handle("/healthz", healthHandler(func() error {
+			if !mq.Stats().Connected {
+				return fmt.Errorf("not connected to MQTT")
+			}
+			if recs == nil && !b.Online() {
+				return fmt.Errorf("device %s is offline", b.Info().DeviceID)
 			}
-			log.Printf("Error, retrying in 5 seconds: %v", err)
-			time.Sleep(5 * time.Second)
+			return nil
+		}))
+	}
+
+	if recs != nil {
+		slog.Info("replaying notifications to MQTT", "notifications", len(recs), "broker", cfg.Address)
+		if err := b.Run(ctx); err != nil {
+			return err
 		}
-		os.Exit(1)
-	}()
+		<-ctx.Done()
+		return nil
+	}
 
-	return tr
+	slog.Info("done initialising, publishing updates to MQTT", "topic", b.Topic(), "broker", cfg.Address)
+	err = b.Run(ctx)
+	// Give the client a moment to send what the bridge published on its
+	// way out before disconnecting
+	time.Sleep(shutdownGrace)
+	return err
 }
 
-func handleObserve(dev client.Device) func(req *coap.Request) {
-	// If the message was confirmable, confirm it before
-	// proceeding with decoding it. This ensures that even
-	// if we hit decoding issues, we always confirm the
-	// message so the device continues sending new messages
-	return func(req *coap.Request) {
-		if req.Msg.IsConfirmable() {
-			m := req.Client.NewMessage(coap.MessageParams{
-				Type:      coap.Acknowledgement,
-				Code:      codes.Empty,
-				MessageID: req.Msg.MessageID(),
-			})
-			m.SetOption(coap.ContentFormat, coap.TextPlain)
-			m.SetOption(coap.LocationPath, req.Msg.Path())
-			if err := req.Client.WriteMsg(m); err != nil {
-				log.Printf("failed to acknowledge message: %v", err)
-			}
+// shutdownGrace is how long to keep the MQTT connection around after the
+// bridge stopped
+const shutdownGrace = time.Second
+
+// fleet publishes all devices from -devices over one MQTT connection. If
+// mux is set the health check is added to it
+func (c *config) fleet(ctx context.Context, opts bridge.Options, mux *http.ServeMux) error {
+	if c.replay != "" {
+		return fmt.Errorf("-replay can't be used with -devices")
+	}
+
+	conf, err := klimatcfg.Load(c.cfgFile)
+	if err != nil {
+		return err
+	}
+	names := splitList(c.devices)
+	if len(names) == 1 && names[0] == "all" {
+		names = conf.Aliases()
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no devices to publish")
+	}
+
+	// Devices in the configuration file are matched by their alias, so a
+	// DeviceID in -debug-devices works whichever way -devices names them
+	debug := map[string]bool{}
+	for _, name := range splitList(c.debugDevices) {
+		if alias, _, err := conf.Lookup(name); err == nil {
+			name = alias
 		}
+		debug[strings.ToLower(name)] = true
+	}
 
-		resp, err := philips.DecodeMessage(req.Msg.Payload())
+	devices := make([]fleet.Device, 0, len(names))
+	for _, name := range names {
+		host, err := klimatcfg.Resolve(c.cfgFile, name, "")
 		if err != nil {
-			log.Printf("failed to decode: %v, payload: %s", err, string(req.Msg.Payload()))
-			return
+			return err
+		}
+		dopts := opts
+		if err := c.lookupDevice(name, &dopts); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if debug[strings.ToLower(name)] || debug[strings.ToLower(dopts.Alias)] {
+			dopts.Debug = true
 		}
+		devices = append(devices, fleet.Device{Name: name, Address: host, Options: dopts})
+	}
 
-		var data philips.Status
-		err = json.Unmarshal(resp, &data)
-		if err != nil {
-			log.Printf("failed to unmarshal JSON: %v", err)
-			return
-		}
-
-		update := data.State.Reported
-
-		dev.Feature("on").Update(update.Power.ToHemtjanst())
-		// Possible states are 0, 1 and 2, but since this device is only a humidifier
-		// it can only ever be 1
-		dev.Feature("targetHumidifierDehumidifierState").Update("1")
-		if update.ChildLock {
-			dev.Feature("lockPhysicalControls").Update("1")
-		} else {
-			dev.Feature("lockPhysicalControls").Update("0")
-		}
-
-		if update.Mode == philips.Manual {
-			dev.Feature("targetAirPurifierState").Update("0")
-			dev.Feature("targetFanState").Update("0")
-		} else {
-			dev.Feature("targetAirPurifierState").Update("1")
-			dev.Feature("targetFanState").Update("1")
-		}
-
-		if update.Power == philips.On {
-			// Only update certain values, like the sensors and operating aspects
-			// if the device is on
-			dev.Feature("brightness").Update(update.Brightness.ToHemtjanst())
-			dev.Feature("currentAirPurifierState").Update("2")
-			dev.Feature("currentFanState").Update("2")
-			dev.Feature("rotationSpeed").Update(update.FanSpeed.ToHemtjanst())
-			dev.Feature("airQuality").Update(update.AirQuality.ToHemtjanst())
-			dev.Feature("pm2_5Density").Update(strconv.Itoa(int(math.Min(float64(update.ParticulateMatter25), 100))))
-			// HomeKit doesn't really have the concept of multiple filters, each of which
-			// could need changing, so flip this value if any of the filters need changing
-			// or cleaning
-			if update.ActiveCarbonFilterReplaceIn <= twoWeeks ||
-				update.HEPAFilterReplaceIn <= twoWeeks ||
-				update.WickReplaceIn <= twoWeeks ||
-				update.PrefilterAndWickCleanIn <= 0 ||
-				update.Err == philips.ErrCleanFilter {
-				dev.Feature("filterChangeIndication").Update("1")
-			} else {
-				dev.Feature("filterChangeIndication").Update("0")
+	cfg, err := c.mqttcfg()
+	if err != nil {
+		return err
+	}
+	mqctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	mq, err := broker.Connect(mqctx, cfg)
+	if err != nil {
+		return err
+	}
+	for i := range devices {
+		devices[i].Options.LastWillID = cfg.ClientID
+	}
+	if err := c.monitors(ctx, mq, cfg.ClientID); err != nil {
+		return err
+	}
+
+	m := fleet.New(mq, fleet.Options{MaxDials: c.maxDials, Stagger: c.stagger})
+	mq.OnReconnect(m.Refresh)
+	if c.socket != "" {
+		if err := daemon.Listen(ctx, c.socket, daemon.NewServer(m.Bridges)); err != nil {
+			return err
+		}
+	}
+	if mux != nil {
+		// One device being offline shouldn't get the whole fleet
+		// restarted, so only fail if none of them are online
+		mux.Handle("/healthz", healthHandler(func() error {
+			if !mq.Stats().Connected {
+				return fmt.Errorf("not connected to MQTT")
+			}
+			for _, b := range m.Bridges() {
+				if b.Online() {
+					return nil
+				}
 			}
-			dev.Feature("currentRelativeHumidity").Update(strconv.Itoa(update.RelativeHumidity))
-			dev.Feature("targetRelativeHumidity").Update(strconv.Itoa(update.RelativeHumidityTarget))
-			dev.Feature("currentHumidifierDehumidifierState").Update(update.Function.ToHemtjanst())
-			dev.Feature("currentTemperature").Update(strconv.Itoa(update.Temperature))
-			dev.Feature("waterLevel").Update(strconv.Itoa(update.WaterLevel))
-		} else {
-			// Set certain values to 0 when we turn the device off so it looks like
-			// it's not doing anything
-			dev.Feature("brightness").Update("0")
-			dev.Feature("currentAirPurifierState").Update("0")
-			dev.Feature("currentFanState").Update("0")
-			dev.Feature("rotationSpeed").Update("0")
-			dev.Feature("currentHumidifierDehumidifierState").Update("0")
+			return fmt.Errorf("none of the devices are online")
+		}))
+	}
+
+	slog.Info("publishing updates to MQTT", "devices", len(devices), "broker", cfg.Address)
+	err = m.Run(ctx, devices)
+	time.Sleep(shutdownGrace)
+	return err
+}
+
+// lookupDevice sets the alias, and the topic, features and announced
+// metadata if the device has them configured, and the air quality mapping
+func (c *config) lookupDevice(name string, opts *bridge.Options) error {
+	conf, err := klimatcfg.Load(c.cfgFile)
+	if err != nil {
+		return err
+	}
+	alias, d, err := conf.Lookup(name)
+	if err != nil {
+		// The registry might still know about the device, Resolve will
+		// report it if it doesn't
+		opts.AirQuality, err = bridge.ParseAirQualityMapping(c.airQuality)
+		return err
+	}
+	opts.Alias = alias
+	if d.Driver != "" && d.Driver != driver.Default {
+		return fmt.Errorf("publish can only bridge %s devices, %s uses the %s driver", driver.Default, alias, d.Driver)
+	}
+	opts.DeviceOptions, err = philips.ParseOptions(opts.DeviceOptions, d.Options)
+	if err != nil {
+		return err
+	}
+	if d.Topic != "" {
+		opts.Topic = d.Topic
+	}
+	if len(d.Features) > 0 && len(opts.Features) == 0 {
+		opts.Features = d.Features
+	}
+	if a := d.Announce; a != nil {
+		opts.Name = orDefault(opts.Name, a.Name)
+		opts.Manufacturer = orDefault(opts.Manufacturer, a.Manufacturer)
+		opts.Model = orDefault(opts.Model, a.Model)
+		opts.Type = orDefault(opts.Type, a.Type)
+	}
+	opts.Scenes = map[string]philips.Settings{}
+	for name, s := range conf.ScenesFor(d) {
+		opts.Scenes[name] = s
+	}
+	opts.Automations, err = automation.FromConfig(d, conf.Location)
+	if err != nil {
+		return err
+	}
+	opts.AirQuality, err = bridge.ParseAirQualityMapping(orDefault(c.airQuality, d.AirQuality))
+	return err
+}
+
+// orDefault returns v, or def if v is empty
+func orDefault(v, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
+
+// splitList splits a comma separated flag value, ignoring empty entries
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
 		}
 	}
+	return res
+}
+
+func readRecording(file string) ([]philips.Recording, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+	return philips.ReadRecording(f)
 }
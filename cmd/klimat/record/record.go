@@ -0,0 +1,115 @@
+package record
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+)
+
+type config struct {
+	opts     func() philips.Options
+	out      io.Writer
+	host     string
+	duration time.Duration
+	file     string
+	cfgFile  string
+	device   string
+}
+
+// NewCmd returns the record subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat record", flag.ExitOnError)
+	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	c.opts = philips.OptionsFlags(fs)
+	fs.DurationVar(&c.duration, "duration", 0, "how long to record for, 0 records until interrupted")
+	fs.StringVar(&c.file, "out", "", "file to write the recording to")
+
+	return &ffcli.Command{
+		Name:       "record",
+		ShortUsage: "record -out <file> [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Record writes every raw notification from a device to a file",
+		LongHelp: "The record command observes the device and writes every " +
+			"notification it receives, as is, to a file together with the " +
+			"device info and session. The recording can be fed back through " +
+			"the status and publish commands using their -replay flag, which " +
+			"is useful to reproduce decoding issues without access to the " +
+			"device.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
+	if err != nil {
+		return err
+	}
+	c.host = host
+
+	if c.file == "" {
+		return flag.ErrHelp
+	}
+
+	f, err := os.Create(c.file)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", c.file, err)
+	}
+	defer f.Close()
+
+	if c.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.duration)
+		defer cancel()
+	}
+
+	cl, err := philips.NewWithOptions(ctx, c.host, c.opts())
+	if err != nil {
+		return err
+	}
+
+	info, err := cl.Info()
+	if err != nil {
+		return err
+	}
+
+	rec, err := philips.NewRecorder(f, c.host, cl, info)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	obs, err := cl.Status(func(req *coap.Request) {
+		if err := philips.Acknowledge(req); err != nil {
+			slog.Warn("failed to acknowledge message", "err", err)
+		}
+		if err := rec.Record(req.Msg.Payload()); err != nil {
+			slog.Warn("failed to record notification", "err", err)
+			return
+		}
+		count++
+		slog.Info("recorded notification", "count", count)
+	})
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	obs.Cancel()
+
+	return nil
+}
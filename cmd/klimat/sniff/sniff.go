@@ -0,0 +1,170 @@
+package sniff
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/pcapng"
+	"hemtjan.st/klimat/sniff"
+)
+
+type config struct {
+	out   io.Writer
+	iface string
+	port  uint
+	host  string
+	json  bool
+	pcap  string
+	enc   *json.Encoder
+}
+
+// event is what gets printed for every message with -json
+type event struct {
+	Time      time.Time `json:"time"`
+	Src       string    `json:"src"`
+	Dst       string    `json:"dst"`
+	Type      string    `json:"type"`
+	Code      string    `json:"code"`
+	MessageID uint16    `json:"message_id"`
+	Token     string    `json:"token,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Observe   *int      `json:"observe,omitempty"`
+	Session   string    `json:"session,omitempty"`
+	Payload   string    `json:"payload,omitempty"`
+	Plain     string    `json:"plain,omitempty"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// NewCmd returns the sniff subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := &config{
+		out: out,
+		enc: json.NewEncoder(out),
+	}
+
+	fs := flag.NewFlagSet("klimat sniff", flag.ExitOnError)
+	fs.StringVar(&c.iface, "interface", "", "network interface to capture on, like eth0")
+	fs.UintVar(&c.port, "port", 5683, "CoAP port of the devices")
+	fs.StringVar(&c.host, "host", "", "only show traffic to and from this IP address")
+	fs.BoolVar(&c.json, "json", false, "print every message as a JSON object")
+	fs.StringVar(&c.pcap, "pcap", "", "also write the messages to this pcapng file, with the decrypted payloads as packet comments")
+
+	return &ffcli.Command{
+		Name:       "sniff",
+		ShortUsage: "sniff -interface <name> [flags]",
+		FlagSet:    fs,
+		ShortHelp:  "Sniff decodes the CoAP traffic of devices on the network",
+		LongHelp: "The sniff command passively captures the CoAP traffic to " +
+			"and from devices, for example between the vendor app and a " +
+			"device, and prints every message with its payload decrypted. " +
+			"Session IDs are picked up from the sync exchange, and commands " +
+			"that don't use the session ID the device expects are pointed " +
+			"out. It only sees traffic that passes the interface, so run it " +
+			"on the router or on a mirror port to follow a phone. It's Linux " +
+			"only and needs root or CAP_NET_RAW.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	if c.iface == "" || c.port == 0 || c.port > 65535 {
+		return flag.ErrHelp
+	}
+	var host netip.Addr
+	if c.host != "" {
+		var err error
+		if host, err = netip.ParseAddr(c.host); err != nil {
+			return fmt.Errorf("invalid -host: %w", err)
+		}
+	}
+
+	var pw *pcapng.Writer
+	if c.pcap != "" {
+		f, err := os.Create(c.pcap)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", c.pcap, err)
+		}
+		defer f.Close()
+		if pw, err = pcapng.NewWriter(f, pcapng.LinkTypeEthernet); err != nil {
+			return fmt.Errorf("failed to write %s: %w", c.pcap, err)
+		}
+	}
+
+	dec := sniff.NewDecoder(uint16(c.port))
+	return sniff.Capture(ctx, c.iface, func(t time.Time, frame []byte) {
+		ev, ok := dec.Decode(t, frame)
+		if !ok {
+			return
+		}
+		if host.IsValid() && ev.Src.Addr() != host && ev.Dst.Addr() != host {
+			return
+		}
+		c.print(ev)
+		if pw != nil {
+			if err := pw.WritePacket(t, frame, string(ev.Plain)); err != nil {
+				slog.Warn("failed to write packet", "file", c.pcap, "err", err)
+			}
+		}
+	})
+}
+
+func (c *config) print(ev *sniff.Event) {
+	m := ev.Msg
+	e := event{
+		Time:      ev.Time,
+		Src:       ev.Src.String(),
+		Dst:       ev.Dst.String(),
+		Type:      m.TypeString(),
+		Code:      m.CodeString(),
+		MessageID: m.MessageID,
+		Token:     hex.EncodeToString(m.Token),
+		Path:      m.Path,
+		Session:   ev.Session,
+		Payload:   string(m.Payload),
+		Plain:     string(ev.Plain),
+		Note:      ev.Note,
+	}
+	if m.Observe >= 0 {
+		e.Observe = &m.Observe
+	}
+	if c.json {
+		c.enc.Encode(e)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s > %s %s %s", e.Time.Format("15:04:05.000"), e.Src, e.Dst, e.Type, e.Code)
+	if e.Path != "" {
+		fmt.Fprintf(&b, " %s", e.Path)
+	}
+	fmt.Fprintf(&b, " mid=%d", e.MessageID)
+	if e.Token != "" {
+		fmt.Fprintf(&b, " token=%s", e.Token)
+	}
+	if e.Observe != nil {
+		fmt.Fprintf(&b, " observe=%d", *e.Observe)
+	}
+	if e.Session != "" {
+		fmt.Fprintf(&b, " session=%s", e.Session)
+	}
+	switch {
+	case e.Plain != "":
+		fmt.Fprintf(&b, "\n  %s", e.Plain)
+	case e.Payload != "" && e.Session == "":
+		fmt.Fprintf(&b, "\n  %s", e.Payload)
+	}
+	if e.Note != "" {
+		fmt.Fprintf(&b, "\n  (%s)", e.Note)
+	}
+	fmt.Fprintln(c.out, b.String())
+}
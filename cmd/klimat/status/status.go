@@ -2,20 +2,28 @@ package status
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"os"
 
 	"github.com/go-ocf/go-coap"
-	"github.com/go-ocf/go-coap/codes"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/aqi"
+	klimatcfg "hemtjan.st/klimat/config"
 	"hemtjan.st/klimat/philips"
 )
 
 type config struct {
-	out  io.Writer
-	host string
+	opts    func() philips.Options
+	out     io.Writer
+	host    string
+	replay  string
+	cfgFile string
+	device  string
+	aqi     string
+	index   aqi.Index
 }
 
 // NewCmd returns the discover subcommand
@@ -26,6 +34,11 @@ func NewCmd(out io.Writer) *ffcli.Command {
 
 	fs := flag.NewFlagSet("klimat status", flag.ExitOnError)
 	fs.StringVar(&c.host, "address", "localhost:5683", "host:port to connect to")
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file to use instead of -address")
+	c.opts = philips.OptionsFlags(fs)
+	fs.StringVar(&c.aqi, "aqi", "epa", "air quality index to compute from PM2.5: epa or china, empty disables it")
+	fs.StringVar(&c.replay, "replay", "", "decode the notifications from a recording instead of a device")
 
 	return &ffcli.Command{
 		Name:       "status",
@@ -37,38 +50,44 @@ func NewCmd(out io.Writer) *ffcli.Command {
 }
 
 func (c *config) Exec(ctx context.Context, args []string) error {
-	cl, err := philips.New(ctx, c.host)
+	if c.aqi != "" {
+		idx, err := aqi.Lookup(c.aqi)
+		if err != nil {
+			return err
+		}
+		c.index = idx
+	}
+
+	host, err := klimatcfg.Resolve(c.cfgFile, c.device, c.host)
 	if err != nil {
 		return err
 	}
+	c.host = host
 
-	obs, err := cl.Status(func(req *coap.Request) {
-		if req.Msg.IsConfirmable() {
-			m := req.Client.NewMessage(coap.MessageParams{
-				Type:      coap.Acknowledgement,
-				Code:      codes.Empty,
-				MessageID: req.Msg.MessageID(),
-			})
-			m.SetOption(coap.ContentFormat, coap.TextPlain)
-			m.SetOption(coap.LocationPath, req.Msg.Path())
-			if err := req.Client.WriteMsg(m); err != nil {
-				log.Printf("failed to acknowledge message: %v", err)
-			}
+	if c.replay != "" {
+		f, err := os.Open(c.replay)
+		if err != nil {
+			return fmt.Errorf("failed to open recording: %w", err)
 		}
+		defer f.Close()
 
-		resp, err := philips.DecodeMessage(req.Msg.Payload())
+		recs, err := philips.ReadRecording(f)
 		if err != nil {
-			log.Printf("failed to decode: %v, payload: %s", err, string(req.Msg.Payload()))
-			return
+			return err
 		}
+		return philips.Replay(ctx, recs, false, c.handle)
+	}
 
-		var data philips.Status
-		err = json.Unmarshal(resp, &data)
-		if err != nil {
-			log.Printf("failed to unmarshal JSON: %v", err)
-			return
+	cl, err := philips.NewWithOptions(ctx, c.host, c.opts())
+	if err != nil {
+		return err
+	}
+
+	obs, err := cl.Status(func(req *coap.Request) {
+		if err := philips.Acknowledge(req); err != nil {
+			slog.Warn("failed to acknowledge message", "err", err)
 		}
-		log.Printf("%++v", data.State.Reported)
+		c.handle(req.Msg.Payload())
 	})
 
 	if err != nil {
@@ -80,3 +99,16 @@ func (c *config) Exec(ctx context.Context, args []string) error {
 
 	return nil
 }
+
+func (c *config) handle(payload []byte) {
+	data, err := philips.DecodeStatus(payload)
+	if err != nil {
+		slog.Warn("failed to decode status", "err", err, "payload", string(payload))
+		return
+	}
+	attrs := []any{"state", fmt.Sprintf("%+v", data.State.Reported)}
+	if c.index.Name != "" {
+		attrs = append(attrs, "aqi", c.index.PM25(float64(data.State.Reported.ParticulateMatter25)), "index", c.index.Name)
+	}
+	slog.Info("received status", attrs...)
+}
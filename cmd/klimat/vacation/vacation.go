@@ -0,0 +1,104 @@
+package vacation
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"hemtjan.st/klimat/bridge"
+	"hemtjan.st/klimat/cmd/klimat/broker"
+	klimatcfg "hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/transport/mqtt"
+)
+
+type config struct {
+	out     io.Writer
+	mqttcfg func() (*mqtt.Config, error)
+	cfgFile string
+	device  string
+	topic   string
+}
+
+// NewCmd returns the vacation subcommand
+func NewCmd(out io.Writer) *ffcli.Command {
+	c := config{
+		out: out,
+	}
+
+	fs := flag.NewFlagSet("klimat vacation", flag.ExitOnError)
+	c.mqttcfg = broker.Flags(fs)
+	fs.StringVar(&c.cfgFile, "config", klimatcfg.DefaultPath(), "path to the configuration file")
+	fs.StringVar(&c.device, "device", "", "alias or DeviceID from the configuration file")
+	fs.StringVar(&c.topic, "topic", bridge.DefaultTopic, "MQTT topic publish uses for the device, unless the configuration file overrides it")
+
+	return &ffcli.Command{
+		Name:       "vacation",
+		ShortUsage: "vacation [flags] on|off",
+		FlagSet:    fs,
+		ShortHelp:  "Vacation switches vacation mode of a device on or off",
+		LongHelp: "The vacation command switches vacation mode on or off " +
+			"through a running publish. In vacation mode the device only " +
+			"purifies, with the fan on silent and the light ring off, and " +
+			"automations are paused. Switching it off puts the settings " +
+			"back the way they were.",
+		Exec: c.Exec,
+	}
+}
+
+func (c *config) Exec(ctx context.Context, args []string) error {
+	if len(args) != 1 || c.device == "" {
+		return flag.ErrHelp
+	}
+	var value string
+	switch strings.ToLower(args[0]) {
+	case "on":
+		value = "true"
+	case "off":
+		value = "false"
+	default:
+		return flag.ErrHelp
+	}
+
+	conf, err := klimatcfg.Load(c.cfgFile)
+	if err != nil {
+		return err
+	}
+	alias, d, err := conf.Lookup(c.device)
+	if err != nil {
+		return err
+	}
+	if d.DeviceID == "" {
+		return fmt.Errorf("the DeviceID of %s isn't known, run klimat config init first", alias)
+	}
+	tmpl := c.topic
+	if d.Topic != "" {
+		tmpl = d.Topic
+	}
+	topic := bridge.ExpandTopic(tmpl, alias, &philips.Info{
+		DeviceID: d.DeviceID,
+		Name:     d.Name,
+		ModelID:  d.Model,
+	})
+
+	cfg, err := c.mqttcfg()
+	if err != nil {
+		return err
+	}
+	mq, err := broker.Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	mq.Publish(topic+"/vacation/set", []byte(value), false)
+	// Publishing happens in the background, so give the client a moment
+	// to get the message out before we exit
+	time.Sleep(time.Second)
+
+	slog.Info("switched vacation mode", "alias", alias, "on", value == "true")
+	return nil
+}
@@ -0,0 +1,63 @@
+// Command klimatd is the long running half of klimat. It's klimat publish,
+// with the control API served on the default socket, so the klimat command
+// line can use its connections to the devices.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"hemtjan.st/klimat/cmd/klimat/exitcode"
+	"hemtjan.st/klimat/cmd/klimat/publish"
+	"hemtjan.st/klimat/daemon"
+	"hemtjan.st/klimat/logging"
+)
+
+func main() {
+	log.SetOutput(os.Stdout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(c)
+		cancel()
+	}()
+	go func() {
+		select {
+		case <-c:
+			slog.Info("received cancellation signal, shutting down")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	cmd := publish.NewCmd(os.Stdout)
+	cmd.Name = "klimatd"
+	cmd.ShortUsage = "klimatd [flags]"
+	// -socket can still be set to something else, or to nothing to not
+	// serve the control API
+	if err := cmd.FlagSet.Set("socket", daemon.DefaultSocket()); err != nil {
+		panic(err)
+	}
+	cmd.FlagSet.Lookup("socket").DefValue = daemon.DefaultSocket()
+
+	setup := logging.Flags(cmd.FlagSet)
+	exec := cmd.Exec
+	cmd.Exec = func(ctx context.Context, args []string) error {
+		if err := setup(); err != nil {
+			return err
+		}
+		return exec(ctx, args)
+	}
+
+	if err := cmd.ParseAndRun(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitcode.FromError(err))
+	}
+}
@@ -0,0 +1,348 @@
+// Package config handles the klimat configuration file and device registry.
+// They keep track of the devices known to klimat, so that subcommands can
+// refer to a device by an alias or DeviceID instead of its address.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Config is the content of the configuration file
+type Config struct {
+	// Devices maps an alias to a device
+	Devices map[string]*Device `json:"devices"`
+	// Location is where the devices are, for automations that follow the
+	// sun
+	Location *Location `json:"location,omitempty"`
+	// Scenes are named bundles of settings every device can use, by the
+	// names and values of the control subcommand
+	Scenes map[string]map[string]string `json:"scenes,omitempty"`
+	// Monitors maps an alias to an air quality monitor
+	Monitors map[string]*Monitor `json:"monitors,omitempty"`
+	// Allow lists the DeviceIDs klimat may discover and bridge. If it's
+	// empty every device is allowed, unless it's in Deny
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists DeviceIDs klimat leaves alone, like a neighbour's
+	// purifier that shows up on a shared network
+	Deny []string `json:"deny,omitempty"`
+}
+
+// Monitor is an air quality monitor publish can bridge next to the devices
+type Monitor struct {
+	// Driver is the kind of monitor, awair or airgradient
+	Driver string `json:"driver"`
+	// Address is the host or host:port of the monitor's local API
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+	// Topic is the MQTT topic of the monitor, climate/monitor/<alias> by
+	// default
+	Topic string `json:"topic,omitempty"`
+}
+
+// Location is a position on earth, in degrees with north and east positive
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Device is a device known to klimat
+type Device struct {
+	Address  string `json:"address"`
+	DeviceID string `json:"device_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// Driver is the kind of device, see the driver package. Empty means
+	// driver.Default
+	Driver string `json:"driver,omitempty"`
+	// Options configure the driver, what's available depends on the driver
+	Options map[string]string `json:"options,omitempty"`
+	// Topic overrides the MQTT topic template used by publish
+	Topic string `json:"topic,omitempty"`
+	// Features overrides the features announced by publish, for models
+	// klimat doesn't know
+	Features []string `json:"features,omitempty"`
+	// AirQuality is the air quality mapping used by publish, see
+	// bridge.ParseAirQualityMapping
+	AirQuality string `json:"air_quality,omitempty"`
+	// Announce overrides what publish announces the device as
+	Announce *Announce `json:"announce,omitempty"`
+	// Schedules change settings of the device at certain times, while
+	// publish is bridging it
+	Schedules []Schedule `json:"schedules,omitempty"`
+	// Rules change settings or raise alerts based on the readings of the
+	// device, while publish is bridging it
+	Rules []Rule `json:"rules,omitempty"`
+	// Night changes settings of the device between sunset and sunrise at
+	// the Location, while publish is bridging it
+	Night *Night `json:"night,omitempty"`
+	// Humidity keeps the relative humidity in a band, while publish is
+	// bridging the device
+	Humidity *Humidity `json:"humidity,omitempty"`
+	// Boost changes settings while PM2.5 is high, while publish is
+	// bridging the device
+	Boost *Boost `json:"boost,omitempty"`
+	// QuietHours cap the fan speed at certain times, while publish is
+	// bridging the device
+	QuietHours []QuietHours `json:"quiet_hours,omitempty"`
+	// Scenes are scenes only this device has, or that replace the scene
+	// with the same name from Config.Scenes for it
+	Scenes map[string]map[string]string `json:"scenes,omitempty"`
+}
+
+// QuietHours cap the fan speed between From and To
+type QuietHours struct {
+	// Days are the days the window starts on, like Schedule.Days
+	Days string `json:"days,omitempty"`
+	// From and To are the local times of day, as HH:MM. If To is before
+	// From the window runs past midnight
+	From string `json:"from"`
+	To   string `json:"to"`
+	// MaxFan is the fastest fan speed allowed, like the fan subcommand of
+	// control takes
+	MaxFan string `json:"max_fan"`
+}
+
+// Humidity is a band to keep the relative humidity in. Humidification is
+// switched on below Low, and off once the humidity reaches High
+type Humidity struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+	// Fan is the fan speed while humidifying, like the fan subcommand of
+	// control takes. Empty leaves it alone
+	Fan string `json:"fan,omitempty"`
+}
+
+// Boost changes settings once PM2.5 goes above Above, until it has been
+// below Below for For
+type Boost struct {
+	Above int `json:"above"`
+	Below int `json:"below"`
+	// For is how long PM2.5 has to stay below Below, like "10m". Empty
+	// ends the boost right away
+	For string `json:"for,omitempty"`
+	// Set are the settings while boosting, by the names and values of the
+	// control subcommand. They're put back the way they were afterwards.
+	// Empty switches to manual mode with the fan on turbo
+	Set map[string]string `json:"set,omitempty"`
+}
+
+// Night are the settings a device uses at night
+type Night struct {
+	// Set are the settings to change at sunset, by the names and values of
+	// the control subcommand. They're put back the way they were at
+	// sunrise. Empty switches to sleep mode and turns the light ring off
+	Set map[string]string `json:"set,omitempty"`
+}
+
+// Schedule changes settings of a device at a time of day
+type Schedule struct {
+	// Days the schedule runs on: daily, weekdays, weekends or a comma
+	// separated list of days like mon,wed,fri. Empty means daily
+	Days string `json:"days,omitempty"`
+	// At is the local time of day, as HH:MM
+	At string `json:"at"`
+	// Set are the settings to change, by the names and values of the
+	// control subcommand
+	Set map[string]string `json:"set"`
+}
+
+// Rule triggers when a reading of a device crosses a threshold
+type Rule struct {
+	// When is the condition, a reading compared to a number like
+	// "pm25 > 35" or "humidity < 35"
+	When string `json:"when"`
+	// For is how long the condition has to hold before the rule triggers,
+	// like "5m". Empty triggers right away
+	For string `json:"for,omitempty"`
+	// Set are the settings to change when the rule triggers, by the names
+	// and values of the control subcommand
+	Set map[string]string `json:"set,omitempty"`
+	// Alert is the message of the alert to raise while the rule is
+	// triggered
+	Alert string `json:"alert,omitempty"`
+}
+
+// Announce overrides the metadata publish announces a device with. Empty
+// fields keep the default
+type Announce struct {
+	Name         string `json:"name,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Type         string `json:"type,omitempty"`
+}
+
+// ErrUnknownDevice is returned when looking up a device that isn't in the
+// configuration file
+var ErrUnknownDevice = errors.New("unknown device")
+
+// ErrNotAllowed is returned for a device that Allow or Deny keep klimat
+// away from
+var ErrNotAllowed = errors.New("device not allowed by the configuration")
+
+// DefaultPath returns the default location of the configuration file. It
+// falls back to the current directory if the user's configuration
+// directory can't be determined
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "klimat.json"
+	}
+	return filepath.Join(dir, "klimat", "config.json")
+}
+
+// Load reads the configuration file. A file that doesn't exist results in
+// an empty configuration
+func Load(path string) (*Config, error) {
+	c := &Config{Devices: map[string]*Device{}}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if c.Devices == nil {
+		c.Devices = map[string]*Device{}
+	}
+	return c, nil
+}
+
+// Save writes the configuration file, creating the directory it lives in
+// if necessary
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// Lookup finds a device by its alias, or failing that by its DeviceID
+func (c *Config) Lookup(name string) (string, *Device, error) {
+	if d, ok := c.Devices[name]; ok {
+		return name, d, nil
+	}
+	for alias, d := range c.Devices {
+		if d.DeviceID != "" && strings.EqualFold(d.DeviceID, name) {
+			return alias, d, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrUnknownDevice, name)
+}
+
+// ScenesFor returns the scenes a device can use. d can be nil for a device
+// that isn't in the configuration file
+func (c *Config) ScenesFor(d *Device) map[string]map[string]string {
+	res := map[string]map[string]string{}
+	for name, s := range c.Scenes {
+		res[name] = s
+	}
+	if d != nil {
+		for name, s := range d.Scenes {
+			res[name] = s
+		}
+	}
+	return res
+}
+
+// ByDeviceID returns the alias and device with the given DeviceID
+func (c *Config) ByDeviceID(id string) (string, *Device) {
+	for alias, d := range c.Devices {
+		if d.DeviceID == id {
+			return alias, d
+		}
+	}
+	return "", nil
+}
+
+// Allowed reports if the device with the given DeviceID may be touched,
+// according to Allow and Deny
+func (c *Config) Allowed(id string) bool {
+	for _, d := range c.Deny {
+		if strings.EqualFold(d, id) {
+			return false
+		}
+	}
+	if len(c.Allow) == 0 {
+		return true
+	}
+	for _, a := range c.Allow {
+		if strings.EqualFold(a, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Aliases returns the aliases of all devices, sorted
+func (c *Config) Aliases() []string {
+	res := make([]string, 0, len(c.Devices))
+	for alias := range c.Devices {
+		res = append(res, alias)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// MonitorAliases returns the aliases of all monitors, sorted
+func (c *Config) MonitorAliases() []string {
+	res := make([]string, 0, len(c.Monitors))
+	for alias := range c.Monitors {
+		res = append(res, alias)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Resolve returns the address to connect to. If device is empty address is
+// returned as is. Otherwise device is looked up as an alias or DeviceID in
+// the configuration file at path, and in the registry that belongs to it.
+// The registry wins if it knows the device, since discovery keeps it up to
+// date when a device changes address
+func Resolve(path, device, address string) (string, error) {
+	if device == "" {
+		return address, nil
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		return "", err
+	}
+	reg, err := LoadRegistry(RegistryPath(path))
+	if err != nil {
+		return "", err
+	}
+
+	id := device
+	_, d, err := c.Lookup(device)
+	if err == nil {
+		if d.DeviceID == "" {
+			return d.Address, nil
+		}
+		id = d.DeviceID
+	}
+	if _, e, ok := reg.Lookup(id); ok {
+		return e.Address, nil
+	}
+	if d != nil {
+		return d.Address, nil
+	}
+	return "", err
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Registry keeps track of every device discovery has come across, so that
+// devices can be found by their DeviceID even after their address changed
+type Registry struct {
+	// Devices maps a DeviceID to what we know about the device
+	Devices map[string]*Entry `json:"devices"`
+}
+
+// Entry is a device in the registry
+type Entry struct {
+	Address   string    `json:"address"`
+	Name      string    `json:"name,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// RegistryPath returns the location of the registry that belongs to the
+// configuration file at path. It's kept next to it
+func RegistryPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "registry.json")
+}
+
+// HistoryPath returns the directory the history of devices is kept in for
+// the configuration file at path. It's kept next to it
+func HistoryPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "history")
+}
+
+// LoadRegistry reads the registry. A file that doesn't exist results in an
+// empty registry
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{Devices: map[string]*Entry{}}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, fmt.Errorf("failed to read registry: %w", err)
+	}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", path, err)
+	}
+	if r.Devices == nil {
+		r.Devices = map[string]*Entry{}
+	}
+	return r, nil
+}
+
+// Save writes the registry, creating the directory it lives in if necessary
+func (r *Registry) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	// Write to a temporary file first so a crash halfway through doesn't
+	// leave a truncated registry behind
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	return nil
+}
+
+// Seen records that a device was seen at an address
+func (r *Registry) Seen(id, address, name, model string, t time.Time) {
+	e, ok := r.Devices[id]
+	if !ok {
+		e = &Entry{FirstSeen: t}
+		r.Devices[id] = e
+	}
+	e.Address = address
+	e.Name = name
+	e.Model = model
+	e.LastSeen = t
+}
+
+// Lookup finds a device by its DeviceID
+func (r *Registry) Lookup(id string) (string, *Entry, bool) {
+	if e, ok := r.Devices[id]; ok {
+		return id, e, true
+	}
+	for did, e := range r.Devices {
+		if strings.EqualFold(did, id) {
+			return did, e, true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// Client talks to a daemon over its socket
+type Client struct {
+	path string
+	hc   *http.Client
+}
+
+// queryTimeout is how long to wait for the daemon to answer a request
+// that doesn't talk to a device. Setting a device has no timeout of its
+// own: the daemon answers once the device did, which with a fan ramp can
+// take a while, and the context can still cancel it
+const queryTimeout = 5 * time.Second
+
+// NewClient returns a Client for the daemon listening on the socket at
+// path. It doesn't connect until it's used
+func NewClient(path string) *Client {
+	return &Client{
+		path: path,
+		hc: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					conn, err := d.DialContext(ctx, "unix", path)
+					// Only when there's no daemon at all may the caller
+					// talk to the device itself. Once the daemon has the
+					// request, it may already be sending it
+					if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+						return nil, fmt.Errorf("%w: %s: %v", ErrNotRunning, path, err)
+					}
+					return conn, err
+				},
+			},
+		},
+	}
+}
+
+// Devices returns the devices the daemon is bridging
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var res []Device
+	if err := c.do(ctx, http.MethodGet, "/devices", nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Device returns a device the daemon is bridging, by alias or DeviceID. If
+// it isn't bridging it the error is ErrUnknownDevice
+func (c *Client) Device(ctx context.Context, name string) (*Device, error) {
+	var res Device
+	if err := c.do(ctx, http.MethodGet, "/devices/"+url.PathEscape(name), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Status returns the last state a device reported
+func (c *Client) Status(ctx context.Context, name string) (*philips.Reported, error) {
+	var res philips.Reported
+	if err := c.do(ctx, http.MethodGet, "/devices/"+url.PathEscape(name)+"/status", nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Set changes settings on a device, through the connection of the daemon
+func (c *Client) Set(ctx context.Context, name string, settings philips.Settings) error {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, "/devices/"+url.PathEscape(name)+"/set", body, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, v interface{}) error {
+	if method == http.MethodGet {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+		defer cancel()
+	}
+	// The host is ignored, we always dial the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://klimatd"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		if errors.Is(err, ErrNotRunning) {
+			return err
+		}
+		return fmt.Errorf("daemon on %s didn't answer: %w", c.path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e apiError
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			return fmt.Errorf("daemon responded with %s", resp.Status)
+		}
+		if e.Code == codeUnknownDevice {
+			return fmt.Errorf("%w%s", ErrUnknownDevice, strings.TrimPrefix(e.Error, ErrUnknownDevice.Error()))
+		}
+		return errors.New(e.Error)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
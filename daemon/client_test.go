@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+func TestClientNotRunning(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing.sock")
+	if err := NewClient(missing).Set(context.Background(), "bedroom", philips.Settings{"mode": "sleep"}); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("no socket: got %v, want ErrNotRunning", err)
+	}
+
+	// A socket left behind by a daemon that's gone refuses connections
+	stale := filepath.Join(dir, "stale.sock")
+	ln, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if err := NewClient(stale).Set(context.Background(), "bedroom", philips.Settings{"mode": "sleep"}); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("stale socket: got %v, want ErrNotRunning", err)
+	}
+}
+
+func TestClientNotAnswering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "klimat.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Like a daemon busy ramping the fan
+	busy := make(chan struct{})
+	err := Listen(ctx, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-busy
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(busy)
+
+	setCtx, setCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer setCancel()
+	err = NewClient(path).Set(setCtx, "bedroom", philips.Settings{"mode": "sleep"})
+	if err == nil || errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v, want an error other than ErrNotRunning", err)
+	}
+}
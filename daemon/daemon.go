@@ -0,0 +1,82 @@
+// Package daemon serves a control API for the devices a running bridge is
+// connected to, on a unix socket. Commands that go through it use the
+// bridge's CoAP session instead of opening one of their own, which would
+// race with it.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var (
+	// ErrUnknownDevice is returned when the daemon isn't bridging a device
+	ErrUnknownDevice = errors.New("device is not bridged by the daemon")
+	// ErrNotRunning is returned when there's no socket, or nothing is
+	// listening on it
+	ErrNotRunning = errors.New("no daemon is listening")
+)
+
+// Device is a device the daemon is bridging
+type Device struct {
+	Alias    string `json:"alias,omitempty"`
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+	Model    string `json:"model"`
+	Topic    string `json:"topic"`
+	Online   bool   `json:"online"`
+}
+
+// DefaultSocket returns where the socket is by default. That's klimat.sock
+// in $XDG_RUNTIME_DIR, which only the user can access, or in the temporary
+// directory with the uid in its name if that isn't set
+func DefaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "klimat.sock")
+	}
+	return filepath.Join(os.TempDir(), "klimat-"+strconv.Itoa(os.Getuid())+".sock")
+}
+
+// Listen serves h on the unix socket at path until the context is
+// cancelled, and removes the socket afterwards. A socket left behind by a
+// daemon that didn't shut down cleanly is replaced, but one that's still
+// in use is an error
+func Listen(ctx context.Context, path string, h http.Handler) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("another daemon is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	// Anyone who can connect can control the devices
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to restrict access to %s: %w", path, err)
+	}
+
+	srv := &http.Server{Handler: h}
+	go func() {
+		<-ctx.Done()
+		// Closing the listener removes the socket too
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("stopped serving", "subsystem", "daemon", "socket", path, "err", err)
+		}
+	}()
+	return nil
+}
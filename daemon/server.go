@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"hemtjan.st/klimat/bridge"
+	"hemtjan.st/klimat/philips"
+)
+
+// Server is the control API. It serves:
+//
+//	GET  /devices                the devices being bridged
+//	GET  /devices/<name>         one of them, by alias or DeviceID
+//	GET  /devices/<name>/status  the last state it reported
+//	POST /devices/<name>/set     a JSON object of settings to change, like
+//	                             {"mode": "sleep"}
+type Server struct {
+	bridges func() []*bridge.Bridge
+}
+
+// NewServer returns a Server for the bridges the function returns, which
+// is called on every request since devices can come and go
+func NewServer(bridges func() []*bridge.Bridge) *Server {
+	return &Server{bridges: bridges}
+}
+
+// codeUnknownDevice is the code of an ErrUnknownDevice response
+const codeUnknownDevice = "unknown_device"
+
+// apiError is the body of a response that failed. Code is set for errors
+// the client has to tell apart
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "devices" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+		return
+	}
+	if len(parts) == 1 {
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		s.list(w)
+		return
+	}
+
+	b := s.lookup(parts[1])
+	if b == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(apiError{
+			Error: fmt.Sprintf("%s: %s", ErrUnknownDevice, parts[1]),
+			Code:  codeUnknownDevice,
+		})
+		return
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	switch action {
+	case "":
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, describe(b))
+		}
+	case "status":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		state := b.Status()
+		if state == nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("%s hasn't reported its status yet", parts[1]))
+			return
+		}
+		writeJSON(w, state)
+	case "set":
+		if allow(w, r, http.MethodPost) {
+			s.set(w, r, b)
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	res := []Device{}
+	for _, b := range s.bridges() {
+		res = append(res, describe(b))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].DeviceID < res[j].DeviceID
+	})
+	writeJSON(w, res)
+}
+
+func (s *Server) set(w http.ResponseWriter, r *http.Request, b *bridge.Bridge) {
+	var settings philips.Settings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("could not decode settings: %w", err))
+		return
+	}
+	if len(settings) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no settings to change"))
+		return
+	}
+	if _, err := settings.Desired(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := b.Set(settings); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup returns the bridge of the device with name as its alias or
+// DeviceID
+func (s *Server) lookup(name string) *bridge.Bridge {
+	for _, b := range s.bridges() {
+		if strings.EqualFold(b.Alias(), name) || strings.EqualFold(b.Info().DeviceID, name) {
+			return b
+		}
+	}
+	return nil
+}
+
+func describe(b *bridge.Bridge) Device {
+	info := b.Info()
+	return Device{
+		Alias:    b.Alias(),
+		DeviceID: info.DeviceID,
+		Name:     info.Name,
+		Model:    info.ModelID,
+		Topic:    b.Topic(),
+		Online:   b.Online(),
+	}
+}
+
+// allow answers with 405 and returns false if the request doesn't use
+// method
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s %s isn't supported", r.Method, r.URL.Path))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(apiError{Error: err.Error()})
+}
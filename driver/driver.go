@@ -0,0 +1,104 @@
+// Package driver defines what klimat needs from a device, independent of
+// who made it. Every kind of device has a driver that registers itself
+// under a name, like database/sql drivers do, and devices in the
+// configuration file pick theirs with "driver". Philips devices are the
+// default.
+//
+// The control command opens devices through a driver. The bridge doesn't
+// yet, it still needs a *philips.Device, so only Philips devices can be
+// published.
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Default is the driver used when a device doesn't name one
+const Default = "philips"
+
+// Info describes a device
+type Info struct {
+	ID           string
+	Name         string
+	Model        string
+	Manufacturer string
+	Firmware     string
+}
+
+// State is a status update from a device
+type State struct {
+	// Readings are the sensor values, by name: pm25, humidity,
+	// temperature, iaq and water for the ones a device has
+	Readings map[string]float64
+	// Settings are the current settings, by the names and values Set
+	// takes
+	Settings map[string]string
+	// Raw is the update as the device sent it, decrypted, if the driver
+	// has such a thing
+	Raw []byte
+}
+
+// Device is a connection to a device
+type Device interface {
+	// Info returns what the device is
+	Info() (*Info, error)
+	// Watch calls fn with every status update of the device, until the
+	// context is cancelled or watching failed
+	Watch(ctx context.Context, fn func(*State)) error
+	// Set changes settings, by the names and values of the control
+	// subcommand, like {"mode": "sleep"}. All of them are changed in one
+	// command if the device allows it
+	Set(settings map[string]string) error
+	// Close closes the connection
+	Close() error
+}
+
+// Opener connects to a device on address, with the options the device has
+// in the configuration file. Options a driver doesn't know are an error
+type Opener func(ctx context.Context, address string, options map[string]string) (Device, error)
+
+var (
+	mu      sync.Mutex
+	drivers = map[string]Opener{}
+)
+
+// Register makes a driver available under name. It panics if name is
+// already taken, since that's a programming error
+func Register(name string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := drivers[name]; ok {
+		panic("driver: Register called twice for " + name)
+	}
+	drivers[name] = open
+}
+
+// Open connects to a device using the driver registered as name, or
+// Default if name is empty
+func Open(ctx context.Context, name, address string, options map[string]string) (Device, error) {
+	if name == "" {
+		name = Default
+	}
+	mu.Lock()
+	open, ok := drivers[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %q, known drivers are %v", name, Drivers())
+	}
+	return open(ctx, address, options)
+}
+
+// Drivers returns the names of the registered drivers, sorted
+func Drivers() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	res := make([]string, 0, len(drivers))
+	for name := range drivers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Found is a device that responded to a probe
+type Found struct {
+	// Driver is the name of the driver the device belongs to
+	Driver  string
+	Address string
+	Info    Info
+}
+
+// Probe looks for devices of a driver on the network until the context is
+// cancelled, and calls found for every response. Devices can respond more
+// than once, and found can be called from several goroutines at once
+type Probe func(ctx context.Context, found func(Found)) error
+
+var probes = map[string]Probe{}
+
+// RegisterProbe sets how devices of the driver registered as name are
+// found, like CoAP multicast or mDNS. A driver without a probe can't be
+// discovered and has to be added to the configuration file by hand
+func RegisterProbe(name string, p Probe) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := probes[name]; ok {
+		panic("driver: RegisterProbe called twice for " + name)
+	}
+	probes[name] = p
+}
+
+// Discover runs the probes of every driver at the same time, until the
+// context is cancelled. Probes in override replace the registered probe of
+// the same driver, for example to use another multicast address. A probe
+// failing doesn't stop the others, all their errors are returned
+func Discover(ctx context.Context, override map[string]Probe, found func(Found)) error {
+	mu.Lock()
+	run := make(map[string]Probe, len(probes))
+	for name, p := range probes {
+		run[name] = p
+	}
+	mu.Unlock()
+	for name, p := range override {
+		run[name] = p
+	}
+
+	var (
+		wg   sync.WaitGroup
+		emu  sync.Mutex
+		errs []error
+	)
+	for name, p := range run {
+		wg.Add(1)
+		go func(name string, p Probe) {
+			defer wg.Done()
+			if err := p(ctx, found); err != nil {
+				emu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				emu.Unlock()
+			}
+		}(name, p)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,170 @@
+// Package fleet bridges many devices at once over a shared MQTT connection.
+// Connecting to the devices is limited to a few at a time and spread out,
+// and every device runs on its own so one that misbehaves can't hold up the
+// others.
+package fleet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/bridge"
+	"hemtjan.st/klimat/config"
+	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/device"
+)
+
+const (
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Device is a device to bridge
+type Device struct {
+	// Name identifies the device in the logs, usually its alias
+	Name    string
+	Address string
+	Options bridge.Options
+}
+
+// Options configure a Manager
+type Options struct {
+	// MaxDials is how many devices are connected to at the same time. Zero
+	// or less means there's no limit
+	MaxDials int
+	// Stagger is how long to wait between starting on one device and the
+	// next, so they don't all start observing at once
+	Stagger time.Duration
+}
+
+// Manager runs a bridge for every device
+type Manager struct {
+	tr    device.Transport
+	opts  Options
+	dials chan struct{}
+
+	mu      sync.Mutex
+	bridges map[string]*bridge.Bridge
+}
+
+// New returns a Manager publishing to transport
+func New(transport device.Transport, opts Options) *Manager {
+	m := &Manager{
+		tr:      transport,
+		opts:    opts,
+		bridges: map[string]*bridge.Bridge{},
+	}
+	if opts.MaxDials > 0 {
+		m.dials = make(chan struct{}, opts.MaxDials)
+	}
+	return m
+}
+
+// Run bridges the devices until the context is cancelled. A device that
+// can't be connected to is retried with a backoff, without affecting the
+// others
+func (m *Manager) Run(ctx context.Context, devices []Device) error {
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		wg.Add(1)
+		go func(d Device, delay time.Duration) {
+			defer wg.Done()
+			m.run(ctx, d, delay)
+		}(d, time.Duration(i)*m.opts.Stagger)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Refresh publishes the state of every device again, see bridge.Refresh
+func (m *Manager) Refresh() {
+	for _, b := range m.Bridges() {
+		b.Refresh()
+	}
+}
+
+// Bridges returns the bridges of the devices that are connected
+func (m *Manager) Bridges() []*bridge.Bridge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bridges := make([]*bridge.Bridge, 0, len(m.bridges))
+	for _, b := range m.bridges {
+		bridges = append(bridges, b)
+	}
+	return bridges
+}
+
+func (m *Manager) run(ctx context.Context, d Device, delay time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+
+	backoff := minBackoff
+	var b *bridge.Bridge
+	for {
+		var err error
+		b, err = m.connect(ctx, d)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, config.ErrNotAllowed) {
+			slog.Error("not bridging device", "subsystem", "fleet", "name", d.Name, "err", err)
+			return
+		}
+		slog.Warn("failed to connect to device", "subsystem", "fleet", "name", d.Name, "retry", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	m.mu.Lock()
+	m.bridges[d.Name] = b
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.bridges, d.Name)
+		m.mu.Unlock()
+	}()
+
+	slog.Info("publishing updates", "subsystem", "fleet", "name", d.Name, "topic", b.Topic())
+	if err := b.Run(ctx); err != nil {
+		slog.Error("stopped bridging device", "subsystem", "fleet", "name", d.Name, "err", err)
+	}
+}
+
+// connect connects to a device and sets up its bridge, waiting for a free
+// dial if MaxDials is set
+func (m *Manager) connect(ctx context.Context, d Device) (*bridge.Bridge, error) {
+	if m.dials != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case m.dials <- struct{}{}:
+		}
+		defer func() { <-m.dials }()
+	}
+
+	cl, err := philips.NewWithOptions(ctx, d.Address, d.Options.DeviceOptions)
+	if err != nil {
+		return nil, err
+	}
+	b, err := bridge.NewBridge(cl, m.tr, d.Options)
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+	return b, nil
+}
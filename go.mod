@@ -1,6 +1,6 @@
 module hemtjan.st/klimat
 
-go 1.14
+go 1.21
 
 require (
 	github.com/go-ocf/go-coap v0.0.0-20200511140640-db6048acfdd3
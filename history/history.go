@@ -0,0 +1,159 @@
+// Package history keeps the status updates of devices on disk, so past
+// readings can be looked up without running a database. Every device gets
+// a directory with a JSON lines file per day, and days older than the
+// retention are removed.
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+const dayFormat = "2006-01-02"
+
+// Sample is a status update of a device
+type Sample struct {
+	Time     time.Time         `json:"time"`
+	DeviceID string            `json:"device_id"`
+	State    *philips.Reported `json:"state"`
+}
+
+// Store is a history kept in a directory
+type Store struct {
+	dir       string
+	retention time.Duration
+	mu        sync.Mutex
+}
+
+// New returns a Store in dir. Days older than retention are removed by
+// Run, zero keeps everything
+func New(dir string, retention time.Duration) *Store {
+	return &Store{dir: dir, retention: retention}
+}
+
+func (s *Store) path(deviceID string, day time.Time) string {
+	return filepath.Join(s.dir, deviceID, day.UTC().Format(dayFormat)+".jsonl")
+}
+
+// Add appends a status update of a device
+func (s *Store) Add(deviceID string, t time.Time, r *philips.Reported) error {
+	line, err := json.Marshal(Sample{Time: t.UTC(), DeviceID: deviceID, State: r})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(deviceID, t)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// Query returns the samples of a device between from and to, oldest first
+func (s *Store) Query(deviceID string, from, to time.Time) ([]Sample, error) {
+	var res []Sample
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		samples, err := readDay(s.path(deviceID, day))
+		if err != nil {
+			return nil, err
+		}
+		for _, sm := range samples {
+			if !sm.Time.Before(from) && !sm.Time.After(to) {
+				res = append(res, sm)
+			}
+		}
+	}
+	return res, nil
+}
+
+func readDay(path string) ([]Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var res []Sample
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var sm Sample
+		// A line can be cut short if we crashed while writing it
+		if err := json.Unmarshal(sc.Bytes(), &sm); err != nil {
+			continue
+		}
+		res = append(res, sm)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time.Before(res[j].Time) })
+	return res, nil
+}
+
+// Prune removes the days that are entirely older than the retention
+func (s *Store) Prune(now time.Time) error {
+	if s.retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-s.retention).UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(s.dir, "*", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		day, err := time.Parse(dayFormat, strings.TrimSuffix(filepath.Base(f), ".jsonl"))
+		if err != nil {
+			continue
+		}
+		if day.Add(24 * time.Hour).Before(cutoff) {
+			if err := os.Remove(f); err != nil {
+				return fmt.Errorf("failed to remove old history: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run prunes the history every hour until the context is cancelled
+func (s *Store) Run(ctx context.Context) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		if err := s.Prune(time.Now()); err != nil {
+			slog.Warn("failed to prune history", "subsystem", "history", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
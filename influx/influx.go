@@ -0,0 +1,144 @@
+// Package influx writes status updates to InfluxDB using the v2 write API
+// and line protocol.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// Measurement is the measurement status updates are written to
+const Measurement = "klimat"
+
+// queue is how many updates can wait to be written before new ones are
+// dropped, for when InfluxDB is slow or down
+const queue = 256
+
+// Writer writes status updates to a bucket
+type Writer struct {
+	url    string
+	token  string
+	client *http.Client
+	lines  chan string
+}
+
+// New returns a Writer for the InfluxDB at addr, like http://localhost:8086
+func New(addr, org, bucket, token string) (*Writer, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %w", err)
+	}
+	if org == "" || bucket == "" {
+		return nil, fmt.Errorf("both an InfluxDB org and bucket are needed")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	u.RawQuery = url.Values{"org": {org}, "bucket": {bucket}, "precision": {"s"}}.Encode()
+
+	return &Writer{
+		url:    u.String(),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+		lines:  make(chan string, queue),
+	}, nil
+}
+
+// Add queues a status update of a device to be written. It doesn't block,
+// if the queue is full the update is dropped
+func (w *Writer) Add(deviceID string, t time.Time, r *philips.Reported) {
+	select {
+	case w.lines <- Line(deviceID, t, r):
+	default:
+		slog.Warn("dropping status update, InfluxDB can't keep up", "subsystem", "influx", "device", deviceID)
+	}
+}
+
+// Run writes the queued updates until the context is cancelled. Whatever
+// is queued at the time is written in one go
+func (w *Writer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-w.lines:
+			batch := []string{line}
+		more:
+			for {
+				select {
+				case line := <-w.lines:
+					batch = append(batch, line)
+				default:
+					break more
+				}
+			}
+			if err := w.write(ctx, batch); err != nil {
+				slog.Warn("failed to write to InfluxDB", "subsystem", "influx", "lines", len(batch), "err", err)
+			}
+		}
+	}
+}
+
+func (w *Writer) write(ctx context.Context, lines []string) error {
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Line returns the line protocol for a status update, tagged with the
+// DeviceID
+func Line(deviceID string, t time.Time, r *philips.Reported) string {
+	fields := []string{
+		"power=" + quote(string(r.Power)),
+		"mode=" + quote(string(r.Mode)),
+		"function=" + quote(string(r.Function)),
+		"fan_speed=" + quote(string(r.FanSpeed)),
+		"pm25=" + integer(r.ParticulateMatter25),
+		"air_quality=" + integer(int(r.AirQuality)),
+		"relative_humidity=" + integer(r.RelativeHumidity),
+		"relative_humidity_target=" + integer(r.RelativeHumidityTarget),
+		"temperature=" + integer(r.Temperature),
+		"water_level=" + integer(r.WaterLevel),
+		"error=" + integer(int(r.Err)),
+	}
+	return fmt.Sprintf("%s,device=%s %s %d", Measurement, tagEscaper.Replace(deviceID), strings.Join(fields, ","), t.Unix())
+}
+
+var (
+	tagEscaper   = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	fieldEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+func quote(s string) string {
+	return `"` + fieldEscaper.Replace(s) + `"`
+}
+
+func integer(v int) string {
+	return strconv.Itoa(v) + "i"
+}
@@ -0,0 +1,92 @@
+// Package logging sets up the structured logger used by all subcommands.
+package logging
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFlag is returned when a logging flag has a value that isn't
+// valid
+var ErrInvalidFlag = errors.New("invalid logging flag")
+
+// Flags adds the logging flags to fs. The returned function sets up the
+// default logger accordingly, call it once the flags are parsed
+func Flags(fs *flag.FlagSet) func() error {
+	level := fs.String("log-level", "info", "minimum level to log: debug, info, warn or error")
+	format := fs.String("log-format", "text", "log format: text or json")
+	file := fs.String("log-file", "", "file to log to instead of stdout")
+	maxSize := fs.Int("log-max-size", 10, "rotate -log-file once it's bigger than this many megabytes, 0 disables it")
+	maxAge := fs.Duration("log-max-age", 7*24*time.Hour, "rotate -log-file once it's been written to for this long, 0 disables it")
+	keep := fs.Int("log-keep", 5, "how many rotated log files to keep")
+	noRedact := fs.Bool("no-redact", false, "log DeviceIDs and ProductIDs as they are instead of hashing them, for local debugging")
+
+	return func() error {
+		var w io.Writer = os.Stdout
+		if *file != "" {
+			r, err := newRotator(*file, int64(*maxSize)<<20, *maxAge, *keep)
+			if err != nil {
+				return err
+			}
+			w = r
+		}
+		h, err := NewHandler(w, *level, *format)
+		if err != nil {
+			return err
+		}
+		if !*noRedact {
+			h = Redact(h)
+		}
+		slog.SetDefault(slog.New(h))
+		return nil
+	}
+}
+
+// NewHandler returns a handler writing to w in format, text or json, that
+// logs records of at least level
+func NewHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("%w: log level %q, use debug, info, warn or error", ErrInvalidFlag, level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("%w: log format %q, use text or json", ErrInvalidFlag, format)
+	}
+}
+
+// Verbose returns a logger that also logs debug records, whatever the
+// level of the default logger is. It's used to debug a single device
+// without turning on debug logging for everything
+func Verbose(l *slog.Logger) *slog.Logger {
+	return slog.New(verbose{l.Handler()})
+}
+
+type verbose struct {
+	slog.Handler
+}
+
+func (v verbose) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= slog.LevelDebug
+}
+
+func (v verbose) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return verbose{v.Handler.WithAttrs(attrs)}
+}
+
+func (v verbose) WithGroup(name string) slog.Handler {
+	return verbose{v.Handler.WithGroup(name)}
+}
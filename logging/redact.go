@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// identifiers are the attributes holding a DeviceID or ProductID
+var identifiers = map[string]bool{
+	"device":     true,
+	"device_id":  true,
+	"product_id": true,
+}
+
+// embedded matches identifiers inside other values, like the DeviceId and
+// ProductId of a status payload
+var embedded = regexp.MustCompile(`(?i)(\b(?:device|product)_?id"?\s*[:=]\s*"?)([^\s",}\]]+)`)
+
+// minKnown is the shortest identifier that is replaced wherever it shows up,
+// shorter ones would match too much
+const minKnown = 6
+
+// RedactID returns a short hash of an identifier. The same identifier
+// always gets the same hash, whatever its case, so records about one device
+// can still be told apart from another's
+func RedactID(id string) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(id)))
+	return "#" + hex.EncodeToString(sum[:4])
+}
+
+// Redact returns a handler that replaces DeviceIDs and ProductIDs with
+// RedactID before passing records on to h, so logs can be posted in an
+// issue as they are. Identifiers are also replaced wherever they show up
+// once they've been logged, like in MQTT topics built from the DeviceID
+func Redact(h slog.Handler) slog.Handler {
+	return redact{Handler: h, known: &known{ids: map[string]bool{}}}
+}
+
+type redact struct {
+	slog.Handler
+	known *known
+}
+
+func (r redact) Handle(ctx context.Context, rec slog.Record) error {
+	rec.Attrs(func(a slog.Attr) bool {
+		r.known.learn(a)
+		return true
+	})
+	out := slog.NewRecord(rec.Time, rec.Level, rec.Message, rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(r.known.redact(a))
+		return true
+	})
+	return r.Handler.Handle(ctx, out)
+}
+
+func (r redact) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for _, a := range attrs {
+		r.known.learn(a)
+	}
+	res := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		res[i] = r.known.redact(a)
+	}
+	return redact{Handler: r.Handler.WithAttrs(res), known: r.known}
+}
+
+func (r redact) WithGroup(name string) slog.Handler {
+	return redact{Handler: r.Handler.WithGroup(name), known: r.known}
+}
+
+// known are the identifiers logged so far
+type known struct {
+	mu  sync.RWMutex
+	ids map[string]bool
+	// re matches any of ids
+	re *regexp.Regexp
+}
+
+// learn records the identifiers in a
+func (k *known) learn(a slog.Attr) {
+	v := a.Value.Resolve()
+	switch {
+	case v.Kind() == slog.KindGroup:
+		for _, g := range v.Group() {
+			k.learn(g)
+		}
+	case identifiers[a.Key]:
+		k.add(v.String())
+	case v.Kind() == slog.KindString:
+		for _, m := range embedded.FindAllStringSubmatch(v.String(), -1) {
+			k.add(m[2])
+		}
+	}
+}
+
+func (k *known) add(id string) {
+	if len(id) < minKnown {
+		return
+	}
+	k.mu.RLock()
+	ok := k.ids[id]
+	k.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.ids[id] = true
+	quoted := make([]string, 0, len(k.ids))
+	for id := range k.ids {
+		quoted = append(quoted, regexp.QuoteMeta(id))
+	}
+	// Longer identifiers first, in case one contains another
+	sort.Slice(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+	re := "(?i)" + quoted[0]
+	for _, q := range quoted[1:] {
+		re += "|" + q
+	}
+	k.re = regexp.MustCompile(re)
+}
+
+func (k *known) redact(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch {
+	case v.Kind() == slog.KindGroup:
+		group := v.Group()
+		res := make([]slog.Attr, len(group))
+		for i, g := range group {
+			res[i] = k.redact(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(res...)}
+	case identifiers[a.Key]:
+		return slog.String(a.Key, RedactID(v.String()))
+	case v.Kind() == slog.KindString:
+		return slog.String(a.Key, k.redactString(v.String()))
+	case v.Kind() == slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, k.redactString(err.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// redactString replaces the identifiers in s
+func (k *known) redactString(s string) string {
+	s = embedded.ReplaceAllStringFunc(s, func(m string) string {
+		sub := embedded.FindStringSubmatch(m)
+		return sub[1] + RedactID(sub[2])
+	})
+	k.mu.RLock()
+	re := k.re
+	k.mu.RUnlock()
+	if re == nil {
+		return s
+	}
+	return re.ReplaceAllStringFunc(s, RedactID)
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotator writes to a file, which is rotated once it grows beyond maxSize
+// bytes or was opened longer than maxAge ago. Only the keep most recent
+// rotated files are kept
+type rotator struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotator(path string, maxSize int64, maxAge time.Duration, keep int) (*rotator, error) {
+	r := &rotator{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotator) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	full := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	old := r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+	if full || old {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// open opens the log file, appending to it if it exists
+func (r *rotator) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	r.f, r.size, r.opened = f, st.Size(), time.Now()
+	return nil
+}
+
+// rotate moves the current file aside with the time as suffix, opens a new
+// one and removes the rotated files we don't need to keep
+func (r *rotator) rotate() error {
+	r.f.Close()
+	rotated := r.path + "." + time.Now().Format("20060102-150405")
+	if err := os.Rename(r.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	old, err := filepath.Glob(r.path + ".*")
+	if err != nil || len(old) <= r.keep {
+		return nil
+	}
+	// The suffixes sort by time
+	sort.Strings(old)
+	for _, f := range old[:len(old)-r.keep] {
+		os.Remove(f)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package metrics keeps track of sensor readings and bridge counters, and
+// serves them in the Prometheus text format. It only implements the bits
+// klimat needs, so we don't have to pull in the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type kind string
+
+const (
+	gauge   kind = "gauge"
+	counter kind = "counter"
+	summary kind = "summary"
+)
+
+type desc struct {
+	kind kind
+	help string
+}
+
+// descs are the metrics we know about
+var descs = map[string]desc{
+	"klimat_pm25_density":              {gauge, "PM2.5 density in µg/m³."},
+	"klimat_relative_humidity_percent": {gauge, "Relative humidity in percent."},
+	"klimat_temperature_celsius":       {gauge, "Temperature in degrees Celsius."},
+	"klimat_water_level_percent":       {gauge, "Water level of the tank in percent."},
+	"klimat_filter_hours_remaining":    {gauge, "Hours until a filter needs cleaning or replacing."},
+	"klimat_reconnects_total":          {counter, "Times the bridge reconnected to the device."},
+	"klimat_decode_errors_total":       {counter, "Notifications from the device that couldn't be decoded."},
+	"klimat_command_duration_seconds":  {summary, "Time it took the device to accept a command."},
+}
+
+// Metrics holds the current value of every series
+type Metrics struct {
+	mu sync.Mutex
+	// series maps the name of a sample to its labels and value
+	series map[string]map[string]float64
+}
+
+// New returns an empty Metrics
+func New() *Metrics {
+	return &Metrics{series: map[string]map[string]float64{}}
+}
+
+// Set sets a gauge. labels are pairs of label names and values
+func (m *Metrics) Set(name string, v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sample(name)[formatLabels(labels)] = v
+}
+
+// Add adds to a counter
+func (m *Metrics) Add(name string, v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sample(name)[formatLabels(labels)] += v
+}
+
+// Observe adds an observation to a summary
+func (m *Metrics) Observe(name string, v float64, labels ...string) {
+	l := formatLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sample(name + "_sum")[l] += v
+	m.sample(name + "_count")[l]++
+}
+
+func (m *Metrics) sample(name string) map[string]float64 {
+	s, ok := m.series[name]
+	if !ok {
+		s = map[string]float64{}
+		m.series[name] = s
+	}
+	return s
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes all metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(descs))
+	for name := range descs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		d := descs[name]
+		samples := []string{name}
+		if d.kind == summary {
+			samples = []string{name + "_sum", name + "_count"}
+		}
+		if len(m.series[samples[0]]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", name, d.help, name, d.kind)
+		for _, s := range samples {
+			series := m.series[s]
+			labels := make([]string, 0, len(series))
+			for l := range series {
+				labels = append(labels, l)
+			}
+			sort.Strings(labels)
+			for _, l := range labels {
+				fmt.Fprintf(&sb, "%s%s %g\n", s, l, series[l])
+			}
+		}
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels turns label name and value pairs into {name="value",...}
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], escaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
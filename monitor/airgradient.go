@@ -0,0 +1,53 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+)
+
+// airGradient reads an AirGradient monitor through the local API of its
+// firmware
+type airGradient struct {
+	url    string
+	client *http.Client
+}
+
+// airGradientData is the part of /measures/current we use. Newer firmware
+// also reports values compensated for the sensors' known errors, which are
+// used when they're there
+type airGradientData struct {
+	PM02            *float64 `json:"pm02"`
+	PM02Compensated *float64 `json:"pm02Compensated"`
+	PM10            *float64 `json:"pm10"`
+	RCO2            *float64 `json:"rco2"`
+	Atmp            *float64 `json:"atmp"`
+	AtmpCompensated *float64 `json:"atmpCompensated"`
+	Rhum            *float64 `json:"rhum"`
+	RhumCompensated *float64 `json:"rhumCompensated"`
+}
+
+func (a *airGradient) Manufacturer() string {
+	return "AirGradient"
+}
+
+func (a *airGradient) Read(ctx context.Context) (*Reading, error) {
+	var d airGradientData
+	if err := getJSON(ctx, a.client, a.url, &d); err != nil {
+		return nil, err
+	}
+	return &Reading{
+		PM25:        either(d.PM02Compensated, d.PM02),
+		PM10:        d.PM10,
+		CO2:         d.RCO2,
+		Temperature: either(d.AtmpCompensated, d.Atmp),
+		Humidity:    either(d.RhumCompensated, d.Rhum),
+	}, nil
+}
+
+// either returns a, or b if a is nil
+func either(a, b *float64) *float64 {
+	if a != nil {
+		return a
+	}
+	return b
+}
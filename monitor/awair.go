@@ -0,0 +1,40 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+)
+
+// awair reads an Awair Element through its local API, which has to be
+// enabled in the Awair Home app first
+type awair struct {
+	url    string
+	client *http.Client
+}
+
+// awairData is the part of /air-data/latest we use
+type awairData struct {
+	Temp  *float64 `json:"temp"`
+	Humid *float64 `json:"humid"`
+	CO2   *float64 `json:"co2"`
+	PM25  *float64 `json:"pm25"`
+	PM10  *float64 `json:"pm10_est"`
+}
+
+func (a *awair) Manufacturer() string {
+	return "Awair"
+}
+
+func (a *awair) Read(ctx context.Context) (*Reading, error) {
+	var d awairData
+	if err := getJSON(ctx, a.client, a.url, &d); err != nil {
+		return nil, err
+	}
+	return &Reading{
+		PM25:        d.PM25,
+		PM10:        d.PM10,
+		CO2:         d.CO2,
+		Temperature: d.Temp,
+		Humidity:    d.Humid,
+	}, nil
+}
@@ -0,0 +1,187 @@
+// Package monitor reads air quality monitors over their local API and
+// publishes them as Hemtjänst airQualitySensor devices. They're often paired
+// with a purifier, and unlike the purifier's own sensor they sit somewhere
+// else in the room.
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hemtjan.st/klimat/bridge"
+	"hemtjan.st/klimat/philips"
+	"lib.hemtjan.st/client"
+	"lib.hemtjan.st/device"
+	"lib.hemtjan.st/feature"
+)
+
+// Reading is what a monitor measured. Values the monitor doesn't measure
+// are nil
+type Reading struct {
+	// PM25 and PM10 are in µg/m³
+	PM25 *float64
+	PM10 *float64
+	// CO2 is in ppm
+	CO2 *float64
+	// Temperature is in °C
+	Temperature *float64
+	// Humidity is the relative humidity in %
+	Humidity *float64
+}
+
+// Source reads the current values of a monitor
+type Source interface {
+	// Read returns the latest reading
+	Read(ctx context.Context) (*Reading, error)
+	// Manufacturer returns who makes the monitor
+	Manufacturer() string
+}
+
+// New returns the Source for a driver, awair or airgradient, on address.
+// address is the host or host:port of the monitor's local API
+func New(driver, address string) (Source, error) {
+	if address == "" {
+		return nil, fmt.Errorf("no address for the %s monitor", driver)
+	}
+	base := address
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	cl := &http.Client{Timeout: 10 * time.Second}
+	switch strings.ToLower(driver) {
+	case "awair":
+		return &awair{url: base + "/air-data/latest", client: cl}, nil
+	case "airgradient":
+		return &airGradient{url: base + "/measures/current", client: cl}, nil
+	default:
+		return nil, fmt.Errorf("unknown monitor driver %q, use awair or airgradient", driver)
+	}
+}
+
+// getJSON decodes the JSON response to a GET request into v
+func getJSON(ctx context.Context, cl *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode reading: %w", err)
+	}
+	return nil
+}
+
+// Options configure how a monitor is published
+type Options struct {
+	// Topic is the MQTT topic of the device
+	Topic string
+	// Name is the name to announce the device with
+	Name string
+	// Interval is how often the monitor is read
+	Interval time.Duration
+	// AirQuality maps PM2.5 onto the airQuality feature. Monitors don't
+	// have an indoor allergen index, so a mapping based on it falls back
+	// to bridge.AirQualityPM25
+	AirQuality bridge.AirQualityMapping
+	// LastWillID is the ClientID of the MQTT connection
+	LastWillID string
+}
+
+// features are the features a monitor is published with
+var features = map[string]*feature.Info{
+	"airQuality":              {},
+	"pm2_5Density":            {},
+	"pm10Density":             {},
+	"carbonDioxideLevel":      {},
+	"currentTemperature":      {},
+	"currentRelativeHumidity": {},
+}
+
+// Run reads the monitor every Interval and publishes what it measured,
+// until the context is cancelled. Whether the monitor responds is
+// published to <topic>/availability
+func Run(ctx context.Context, src Source, tr device.Transport, opts Options) error {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.AirQuality.Source != bridge.SourcePM25 {
+		opts.AirQuality = bridge.AirQualityPM25
+	}
+
+	dev, err := client.NewDevice(&device.Info{
+		Topic:        opts.Topic,
+		Name:         opts.Name,
+		Manufacturer: src.Manufacturer(),
+		Type:         "airQualitySensor",
+		LastWillID:   opts.LastWillID,
+		Features:     features,
+	}, tr)
+	if err != nil {
+		return fmt.Errorf("failed to create airQualitySensor device: %w", err)
+	}
+	logger := slog.With("subsystem", "monitor", "monitor", opts.Name)
+
+	available := ""
+	setAvailable := func(value string) {
+		if value != available {
+			available = value
+			tr.Publish(opts.Topic+"/availability", []byte(value), true)
+		}
+	}
+
+	t := time.NewTicker(opts.Interval)
+	defer t.Stop()
+	for {
+		r, err := src.Read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				setAvailable("offline")
+				return nil
+			}
+			logger.Warn("failed to read monitor", "err", err)
+			setAvailable("offline")
+		} else {
+			publish(dev, r, opts.AirQuality)
+			setAvailable("online")
+		}
+
+		select {
+		case <-ctx.Done():
+			setAvailable("offline")
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// publish updates the features of the device with a reading
+func publish(dev client.Device, r *Reading, aq bridge.AirQualityMapping) {
+	update := func(name string, v *float64) {
+		if v != nil {
+			dev.Feature(name).Update(strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	if r.PM25 != nil {
+		dev.Feature("airQuality").Update(aq.Map(&philips.Reported{ParticulateMatter25: int(*r.PM25 + 0.5)}))
+	}
+	update("pm2_5Density", r.PM25)
+	update("pm10Density", r.PM10)
+	update("carbonDioxideLevel", r.CO2)
+	update("currentTemperature", r.Temperature)
+	update("currentRelativeHumidity", r.Humidity)
+}
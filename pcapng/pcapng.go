@@ -0,0 +1,165 @@
+// Package pcapng writes packets to pcapng files, which Wireshark opens
+// directly. Packets can carry a comment, which Wireshark shows next to them,
+// like the decrypted payload of a message.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Link types of the packets in a file
+const (
+	// LinkTypeEthernet is for Ethernet frames
+	LinkTypeEthernet = 1
+	// LinkTypeRaw is for IPv4 or IPv6 packets without a link layer
+	LinkTypeRaw = 101
+)
+
+const (
+	blockSection   = 0x0a0d0d0a
+	blockInterface = 0x00000001
+	blockPacket    = 0x00000006
+	optEnd         = 0
+	optComment     = 1
+)
+
+// Writer writes packets of one link type to a pcapng file
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter writes the header of a file with packets of linkType to w, and
+// returns a Writer for the packets
+func NewWriter(w io.Writer, linkType uint16) (*Writer, error) {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], 0x1a2b3c4d)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	// The length of the section isn't known up front
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	if err := writeBlock(w, blockSection, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkType)
+	// No limit on the captured length of a packet
+	binary.LittleEndian.PutUint32(idb[4:], 0)
+	if err := writeBlock(w, blockInterface, idb); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket writes a packet captured at t. An empty comment is left out
+func (w *Writer) WritePacket(t time.Time, data []byte, comment string) error {
+	body := make([]byte, 20, 20+len(data)+len(comment)+16)
+	// Timestamps are in microseconds, the default resolution
+	us := uint64(t.UnixMicro())
+	binary.LittleEndian.PutUint32(body[0:], 0)
+	binary.LittleEndian.PutUint32(body[4:], uint32(us>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(us))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	body = append(body, pad(data)...)
+	if comment != "" {
+		body = appendOption(body, optComment, []byte(comment))
+		body = appendOption(body, optEnd, nil)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return writeBlock(w.w, blockPacket, body)
+}
+
+// writeBlock writes a block, its body has to be padded to 32 bits already
+func writeBlock(w io.Writer, typ uint32, body []byte) error {
+	b := make([]byte, 8, 12+len(body))
+	size := uint32(12 + len(body))
+	binary.LittleEndian.PutUint32(b[0:], typ)
+	binary.LittleEndian.PutUint32(b[4:], size)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, size)
+	_, err := w.Write(b)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, pad(value)...)
+}
+
+// pad pads b with zeroes to a multiple of 32 bits
+func pad(b []byte) []byte {
+	if n := len(b) % 4; n != 0 {
+		return append(append([]byte(nil), b...), make([]byte, 4-n)...)
+	}
+	return b
+}
+
+// UDP returns an IPv4 or IPv6 packet, for LinkTypeRaw, carrying a UDP
+// datagram with payload from src to dst. The addresses have to be of the
+// same family
+func UDP(src, dst netip.AddrPort, payload []byte) []byte {
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	var pseudo []byte
+	var ip []byte
+	if srcIP.Is4() && dstIP.Is4() {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		// Don't fragment
+		ip[6] = 0x40
+		ip[8] = 64
+		ip[9] = 17
+		s, d := srcIP.As4(), dstIP.As4()
+		copy(ip[12:], s[:])
+		copy(ip[16:], d[:])
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+		pseudo = append(append(append([]byte{}, s[:]...), d[:]...), 0, 17)
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		ip[6] = 17
+		ip[7] = 64
+		s, d := srcIP.As16(), dstIP.As16()
+		copy(ip[8:], s[:])
+		copy(ip[24:], d[:])
+		pseudo = append(append(append([]byte{}, s[:]...), d[:]...), 0, 17)
+	}
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(udp)))
+	sum := checksum(append(pseudo, udp...))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return append(ip, udp...)
+}
+
+// checksum is the Internet checksum, RFC 1071
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
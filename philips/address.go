@@ -0,0 +1,100 @@
+package philips
+
+import (
+	"net"
+	"strconv"
+)
+
+// DefaultPort is the CoAP port the devices listen on
+const DefaultPort = 5683
+
+// NormalizeAddress adds the default port to an address that doesn't have
+// one. It accepts IPv6 literals with or without brackets, so "fe80::1",
+// "[fe80::1%eth0]" and "[fe80::1]:5683" all work
+func NormalizeAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	host := address
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, strconv.Itoa(DefaultPort))
+}
+
+// SameAddress returns true if both addresses point at the same host and
+// port, regardless of how they're written
+func SameAddress(a, b string) bool {
+	ah, ap, err := net.SplitHostPort(NormalizeAddress(a))
+	if err != nil {
+		return false
+	}
+	bh, bp, err := net.SplitHostPort(NormalizeAddress(b))
+	if err != nil {
+		return false
+	}
+	if ap != bp {
+		return false
+	}
+	aip, bip := parseIP(ah), parseIP(bh)
+	if aip == nil || bip == nil {
+		return ah == bh
+	}
+	return aip.Equal(bip)
+}
+
+// network returns the network to use for an address, so that IPv6 literals
+// end up on udp6 and IPv4 literals on udp4. Hostnames are left to the
+// resolver
+func network(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "udp"
+	}
+	ip := parseIP(host)
+	switch {
+	case ip == nil:
+		return "udp"
+	case ip.To4() != nil:
+		return "udp4"
+	default:
+		return "udp6"
+	}
+}
+
+// parseIP parses an IP, ignoring the zone of IPv6 link-local addresses
+func parseIP(host string) net.IP {
+	for i := 0; i < len(host); i++ {
+		if host[i] == '%' {
+			host = host[:i]
+			break
+		}
+	}
+	return net.ParseIP(host)
+}
+
+// BetterAddress returns true if address a is a better way to reach a device
+// than address b. Devices answer discovery on every interface they have, so
+// this is used to pick one: IPv4 is preferred over IPv6, and link-local
+// addresses are only used if there's nothing else
+func BetterAddress(a, b string) bool {
+	return addressRank(a) > addressRank(b)
+}
+
+func addressRank(address string) int {
+	host, _, err := net.SplitHostPort(NormalizeAddress(address))
+	if err != nil {
+		return 0
+	}
+	ip := parseIP(host)
+	switch {
+	case ip == nil:
+		return 1
+	case ip.IsLinkLocalUnicast():
+		return 2
+	case ip.To4() == nil:
+		return 3
+	default:
+		return 4
+	}
+}
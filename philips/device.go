@@ -5,9 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"time"
+	"sync"
 
 	"github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
 )
 
 // Device represents a AirCombi device that you can talk to
@@ -16,36 +17,39 @@ type Device struct {
 	cc   *coap.ClientConn
 	ctx  context.Context
 	id   *Session
+	opts Options
+	// setMu ensures commands go out one at a time, since every command
+	// needs its own session ID
+	setMu sync.Mutex
 }
 
-// New returns a CoAP client configured to talk to a device
+// New returns a CoAP client configured to talk to a device, using the
+// DefaultOptions
 func New(ctx context.Context, address string) (*Device, error) {
-	cl := coap.Client{
-		Net:         "udp",
-		DialTimeout: 5 * time.Second,
-		// Internally the time is divided by 6, so this results in a ping/pong every 5s
-		// which is what the Air Matters app does
-		KeepAlive: coap.MustMakeKeepAlive(30 * time.Second),
-	}
+	return NewWithOptions(ctx, address, DefaultOptions())
+}
 
-	conn, err := cl.DialWithContext(ctx, address)
+// NewWithOptions returns a CoAP client configured to talk to a device
+func NewWithOptions(ctx context.Context, address string, opts Options) (*Device, error) {
+	conn, err := Dial(ctx, address, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error dialing: %w", err)
+		return nil, err
 	}
 
 	d := &Device{
 		cc:   conn,
 		ctx:  ctx,
 		addr: address,
+		opts: opts,
 	}
 
 	sess := NewSession()
-	ctx, cancel := context.WithTimeout(d.ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.RequestTimeout)
 	defer cancel()
 
-	rsp, err := d.cc.PostWithContext(ctx, "/sys/dev/sync", coap.TextPlain, bytes.NewReader([]byte(sess.Hex())))
+	rsp, err := d.post(ctx, "/sys/dev/sync", coap.TextPlain, []byte(sess.Hex()))
 	if err != nil {
-		return nil, fmt.Errorf("failed to post to /sys/dev/sync and get session: %w", err)
+		return nil, withKind(ErrUnreachable, fmt.Errorf("failed to post to /sys/dev/sync and get session: %w", err))
 	}
 
 	id := ParseID(rsp.Payload())
@@ -57,17 +61,17 @@ func New(ctx context.Context, address string) (*Device, error) {
 
 // Info returns the decoded payload from /sys/dev/info
 func (d *Device) Info() (*Info, error) {
-	ctx, cancel := context.WithTimeout(d.ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.RequestTimeout)
 	defer cancel()
 
-	devInfo, err := d.cc.GetWithContext(ctx, "/sys/dev/info")
+	devInfo, err := d.get(ctx, "/sys/dev/info")
 	if err != nil {
-		return nil, fmt.Errorf("failed to get /sys/dev/info: %w", err)
+		return nil, withKind(ErrUnreachable, fmt.Errorf("failed to get /sys/dev/info: %w", err))
 	}
 
 	var info Info
 	if err := json.Unmarshal(devInfo.Payload(), &info); err != nil {
-		return nil, fmt.Errorf("could not decode info: %w", err)
+		return nil, withKind(ErrDecode, fmt.Errorf("could not decode info: %w", err))
 	}
 	return &info, nil
 }
@@ -94,47 +98,164 @@ func (d *Device) Set(msg *Desired) error {
 	if err != nil {
 		return err
 	}
+	return d.control(data)
+}
+
+// SetRaw sends an arbitrary desired state to the device. The message must be
+// a JSON object, and is sent as is. This allows changing settings that
+// Desired doesn't cover
+func (d *Device) SetRaw(desired []byte) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(desired, &obj); err != nil {
+		return fmt.Errorf("desired state must be a JSON object: %w", err)
+	}
+	if len(obj) == 0 {
+		return fmt.Errorf("desired state is empty")
+	}
 
-	newMsg, err := EncodeMessage(d.id, []byte(data))
+	data, err := json.Marshal(map[string]map[string]map[string]json.RawMessage{
+		"state": {"desired": obj},
+	})
 	if err != nil {
 		return err
 	}
+	return d.control(data)
+}
 
-	ctx, cancel := context.WithTimeout(d.ctx, 5*time.Second)
-	defer cancel()
+// control sends an already marshalled command to /sys/dev/control
+func (d *Device) control(data []byte) error {
+	d.setMu.Lock()
+	defer d.setMu.Unlock()
 
-	resp, err := d.cc.PostWithContext(ctx, "/sys/dev/control", coap.AppJSON, bytes.NewReader(newMsg))
+	newMsg, err := EncodeMessage(d.id, data)
 	if err != nil {
 		return err
 	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.RequestTimeout)
+	defer cancel()
+
+	resp, err := d.post(ctx, "/sys/dev/control", coap.AppJSON, newMsg)
+	if err != nil {
+		return withKind(ErrUnreachable, err)
+	}
 	d.id.Increment()
 
 	state := map[string]string{}
 	err = json.Unmarshal(resp.Payload(), &state)
 	if err != nil {
-		return err
+		return withKind(ErrDecode, err)
 	}
 
 	if state["status"] != "success" {
-		return fmt.Errorf("did not manage to set value")
+		return withKind(ErrRejected, fmt.Errorf("did not manage to set value"))
 	}
 	return nil
 }
 
+// GetStatus requests the current status once, instead of observing it. The
+// payload is returned as received, so it can be handled like a notification
+// from Status
+func (d *Device) GetStatus() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.RequestTimeout)
+	defer cancel()
+
+	resp, err := d.get(ctx, "/sys/dev/status")
+	if err != nil {
+		return nil, withKind(ErrUnreachable, fmt.Errorf("failed to get /sys/dev/status: %w", err))
+	}
+	return resp.Payload(), nil
+}
+
 // Status lets you subcrivbe to /sys/dev/status and get updates as the
 // devices has them. You should call Cancel() on the observation once
 // you're done with it
 func (d *Device) Status(callback func(req *coap.Request)) (*coap.Observation, error) {
-	ctx, cancel := context.WithTimeout(d.ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.RequestTimeout)
 	defer cancel()
 
+	if t := d.opts.Trace; t != nil {
+		inner := callback
+		callback = func(req *coap.Request) {
+			t.Trace(false, d.cc.LocalAddr(), d.cc.RemoteAddr(), req.Msg)
+			inner(req)
+		}
+	}
 	obs, err := d.cc.ObserveWithContext(ctx, "/sys/dev/status", callback)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start observe on /sys/dev/status: %w", err)
+		return nil, withKind(ErrUnreachable, fmt.Errorf("failed to start observe on /sys/dev/status: %w", err))
 	}
 	return obs, nil
 }
 
+// get sends a GET request, through the tracer if there is one
+func (d *Device) get(ctx context.Context, path string) (coap.Message, error) {
+	if d.opts.Trace == nil {
+		return d.cc.GetWithContext(ctx, path)
+	}
+	req, err := d.cc.NewGetRequest(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.exchange(ctx, req)
+}
+
+// post sends a POST request, through the tracer if there is one
+func (d *Device) post(ctx context.Context, path string, format coap.MediaType, body []byte) (coap.Message, error) {
+	if d.opts.Trace == nil {
+		return d.cc.PostWithContext(ctx, path, format, bytes.NewReader(body))
+	}
+	req, err := d.cc.NewPostRequest(path, format, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return d.exchange(ctx, req)
+}
+
+// exchange sends a request and waits for the response, tracing both
+func (d *Device) exchange(ctx context.Context, req coap.Message) (coap.Message, error) {
+	t := d.opts.Trace
+	t.Trace(true, d.cc.LocalAddr(), d.cc.RemoteAddr(), req)
+	resp, err := d.cc.ExchangeWithContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	t.Trace(false, d.cc.LocalAddr(), d.cc.RemoteAddr(), resp)
+	return resp, nil
+}
+
+// Acknowledge confirms a notification received through Status if the device
+// asked for it. This should happen before decoding the message, so that even
+// if we hit decoding issues the device continues sending new notifications
+func Acknowledge(req *coap.Request) error {
+	if !req.Msg.IsConfirmable() {
+		return nil
+	}
+	m := req.Client.NewMessage(coap.MessageParams{
+		Type:      coap.Acknowledgement,
+		Code:      codes.Empty,
+		MessageID: req.Msg.MessageID(),
+	})
+	m.SetOption(coap.ContentFormat, coap.TextPlain)
+	m.SetOption(coap.LocationPath, req.Msg.Path())
+	return req.Client.WriteMsg(m)
+}
+
+// Close closes the connection to the device
+func (d *Device) Close() error {
+	return d.cc.Close()
+}
+
+// Address returns the address of the device we're connected to
+func (d *Device) Address() string {
+	return d.addr
+}
+
+// Session returns the hex representation of the current session ID
+func (d *Device) Session() string {
+	return d.id.Hex()
+}
+
 // CoAPClient lets you access the underlying CoAP connection in case you need
 // to do something manually
 func (d *Device) CoAPClient() *coap.ClientConn {
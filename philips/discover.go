@@ -0,0 +1,74 @@
+package philips
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+)
+
+const (
+	// DiscoveryAddress is the multicast address the devices listen on
+	DiscoveryAddress = "224.0.1.187:5683"
+	// DiscoveryAddressIPv6 is the link-local "All CoAP Nodes" IPv6 multicast
+	// address. Link-local multicast needs a zone, so you'll usually want to
+	// use something like [ff02::fd%eth0]:5683 instead. For site-local
+	// discovery use ff05::fd
+	DiscoveryAddressIPv6 = "[ff02::fd]:5683"
+)
+
+// Discover sends a multicast GET for /sys/dev/info to address and calls
+// found for every device that responds with a decodable reply. It keeps
+// listening for replies until the context is cancelled, so the caller
+// should pass a context with a timeout.
+//
+// The devices can be a bit finicky and may not always respond to a
+// discovery request, so it's worth running this a few times.
+func Discover(ctx context.Context, address string, found func(address string, info Info)) error {
+	address = NormalizeAddress(address)
+	client := &coap.MulticastClient{
+		Net:         network(address),
+		DialTimeout: 5 * time.Second,
+	}
+
+	conn, err := client.DialWithContext(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+	defer conn.Close()
+
+	req, err := conn.NewGetRequest("/sys/dev/info")
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	wait, err := conn.PublishMsgWithContext(ctx, req, func(req *coap.Request) {
+		m := req.Client.NewMessage(coap.MessageParams{
+			Type:      coap.Reset,
+			Code:      codes.Empty,
+			MessageID: req.Msg.MessageID(),
+		})
+		// I don't believe we should be sending a reset here, but it's what the
+		// AirMatters app does according to packet captures, so lets do it.
+		// Not being able to send it doesn't stop us from using the reply.
+		_ = req.Client.WriteMsgWithContext(ctx, m)
+
+		var info Info
+		if err := json.Unmarshal(req.Msg.Payload(), &info); err != nil {
+			slog.Debug("could not decode info", "subsystem", "philips", "address", req.Client.RemoteAddr().String(), "err", err)
+			return
+		}
+		found(req.Client.RemoteAddr().String(), info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to do discovery: %w", err)
+	}
+
+	<-ctx.Done()
+	wait.Cancel()
+	return nil
+}
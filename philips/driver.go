@@ -0,0 +1,114 @@
+package philips
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/go-ocf/go-coap"
+	"hemtjan.st/klimat/driver"
+)
+
+func init() {
+	driver.Register("philips", openDriver)
+	driver.RegisterProbe("philips", Probe(DiscoveryAddress))
+}
+
+// Probe returns a driver.Probe that runs multicast discovery on address
+func Probe(address string) driver.Probe {
+	return func(ctx context.Context, found func(driver.Found)) error {
+		return Discover(ctx, address, func(addr string, info Info) {
+			found(driver.Found{Driver: "philips", Address: addr, Info: *driverInfo(&info)})
+		})
+	}
+}
+
+// driverDevice is a Device behind the vendor-neutral driver.Device
+type driverDevice struct {
+	*Device
+}
+
+func openDriver(ctx context.Context, address string, options map[string]string) (driver.Device, error) {
+	opts, err := ParseOptions(DefaultOptions(), options)
+	if err != nil {
+		return nil, err
+	}
+	return OpenDriver(ctx, address, opts)
+}
+
+// OpenDriver is what driver.Open does for the "philips" driver, but with
+// Options instead of the strings of the configuration file, so they can
+// carry a Tracer too
+func OpenDriver(ctx context.Context, address string, opts Options) (driver.Device, error) {
+	d, err := NewWithOptions(ctx, address, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &driverDevice{Device: d}, nil
+}
+
+func (d *driverDevice) Info() (*driver.Info, error) {
+	info, err := d.Device.Info()
+	if err != nil {
+		return nil, err
+	}
+	return driverInfo(info), nil
+}
+
+// driverInfo turns the info of a device into a driver.Info
+func driverInfo(info *Info) *driver.Info {
+	return &driver.Info{
+		ID:           info.DeviceID,
+		Name:         info.Name,
+		Model:        info.ModelID,
+		Manufacturer: "Philips",
+		Firmware:     info.SWVersion,
+	}
+}
+
+func (d *driverDevice) Watch(ctx context.Context, fn func(*driver.State)) error {
+	obs, err := d.Status(func(req *coap.Request) {
+		if err := Acknowledge(req); err != nil {
+			slog.Warn("failed to acknowledge message", "subsystem", "philips", "err", err)
+		}
+		plain, err := DecodeMessage(req.Msg.Payload())
+		if err != nil {
+			slog.Warn("failed to decode status", "subsystem", "philips", "err", err)
+			return
+		}
+		status, err := ParseStatus(plain)
+		if err != nil {
+			slog.Warn("failed to parse status", "subsystem", "philips", "err", err)
+			return
+		}
+		fn(driverState(status.State.Reported, plain))
+	})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	obs.Cancel()
+	return nil
+}
+
+func (d *driverDevice) Set(settings map[string]string) error {
+	msg, err := Settings(settings).Desired()
+	if err != nil {
+		return err
+	}
+	return d.Device.Set(msg)
+}
+
+// driverState turns a reported state into a driver.State
+func driverState(r *Reported, raw []byte) *driver.State {
+	return &driver.State{
+		Readings: map[string]float64{
+			"pm25":        float64(r.ParticulateMatter25),
+			"humidity":    float64(r.RelativeHumidity),
+			"temperature": float64(r.Temperature),
+			"iaq":         float64(r.AirQuality),
+			"water":       float64(r.WaterLevel),
+		},
+		Settings: r.Settings(),
+		Raw:      raw,
+	}
+}
@@ -0,0 +1,39 @@
+package philips
+
+import "errors"
+
+// Errors returned by a Device carry one of these kinds, so callers can tell
+// failures apart with errors.Is without having to parse error messages
+var (
+	// ErrUnreachable means we couldn't talk to the device at all
+	ErrUnreachable = errors.New("device unreachable")
+	// ErrDecode means the device replied, but we couldn't make sense of it
+	ErrDecode = errors.New("could not decode message")
+	// ErrRejected means the device didn't accept a command
+	ErrRejected = errors.New("command rejected")
+	// ErrInvalidOption means one of the Options can't be used
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// kindError attaches one of the error kinds to an error, without changing
+// its message or hiding the error it wraps
+type kindError struct {
+	kind error
+	err  error
+}
+
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strconv"
@@ -169,3 +170,27 @@ func EncodeMessage(sess *Session, msg []byte) ([]byte, error) {
 	outMsg += strings.ToUpper(hex.EncodeToString(shaSum[:]))
 	return []byte(outMsg), nil
 }
+
+// DecodeStatus decodes and unmarshals a notification received from
+// /sys/dev/status
+func DecodeStatus(msg []byte) (*Status, error) {
+	resp, err := DecodeMessage(msg)
+	if err != nil {
+		return nil, withKind(ErrDecode, fmt.Errorf("failed to decode: %w", err))
+	}
+
+	return ParseStatus(resp)
+}
+
+// ParseStatus unmarshals the plaintext of a notification received from
+// /sys/dev/status, as returned by DecodeMessage
+func ParseStatus(plain []byte) (*Status, error) {
+	var data Status
+	if err := json.Unmarshal(plain, &data); err != nil {
+		return nil, withKind(ErrDecode, fmt.Errorf("failed to unmarshal JSON: %w", err))
+	}
+	if data.State.Reported == nil {
+		return nil, withKind(ErrDecode, fmt.Errorf("message did not contain a reported state"))
+	}
+	return &data, nil
+}
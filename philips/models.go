@@ -0,0 +1,41 @@
+package philips
+
+import (
+	"strings"
+)
+
+// Model describes what a model of device is capable of
+type Model struct {
+	// Name is the marketing name of the model
+	Name string
+	// Humidifier is set for the models that can humidify, which also
+	// report a water level and take a humidity target
+	Humidifier bool
+	// HumiditySensor is set for the models that report the relative
+	// humidity
+	HumiditySensor bool
+	// TemperatureSensor is set for the models that report the temperature
+	TemperatureSensor bool
+}
+
+// Models are the models we know the capabilities of, by their type. The
+// type is the model ID without the /XX suffix
+var Models = map[string]Model{
+	"AC2729": {Name: "Series 2000i AirCombi", Humidifier: true, HumiditySensor: true, TemperatureSensor: true},
+	"AC3829": {Name: "Series 3000i AirCombi", Humidifier: true, HumiditySensor: true, TemperatureSensor: true},
+	"AC2889": {Name: "Series 2000i"},
+	"AC3033": {Name: "Series 3000i"},
+	"AC3036": {Name: "Series 3000i"},
+	"AC3039": {Name: "Series 3000i"},
+	"AC1214": {Name: "Series 1000i"},
+}
+
+// LookupModel returns the capabilities of a model. modelID can be the type
+// or the full model ID as reported by the device, like AC2729/10
+func LookupModel(modelID string) (Model, bool) {
+	if i := strings.IndexByte(modelID, '/'); i >= 0 {
+		modelID = modelID[:i]
+	}
+	m, ok := Models[strings.ToUpper(modelID)]
+	return m, ok
+}
@@ -0,0 +1,169 @@
+package philips
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+)
+
+// Options configure how we talk to a device
+type Options struct {
+	// DialTimeout is how long to wait for the connection to be set up
+	DialTimeout time.Duration
+	// RequestTimeout is how long to wait for the device to reply to a request
+	RequestTimeout time.Duration
+	// KeepAlive is the interval after which the connection is considered
+	// dead if the device didn't respond. Zero disables keepalives
+	KeepAlive time.Duration
+	// Trace gets every message exchanged with the device, if set. Pings
+	// and acknowledgements aren't included
+	Trace Tracer
+}
+
+// DefaultOptions returns the options used by New
+func DefaultOptions() Options {
+	return Options{
+		DialTimeout:    5 * time.Second,
+		RequestTimeout: 5 * time.Second,
+		// Internally the time is divided by 6, so this results in a ping/pong every 5s
+		// which is what the Air Matters app does
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// OptionsFlags registers flags for all the options on fs, using the
+// defaults from DefaultOptions. The returned function gives you the
+// options once the flags have been parsed
+func OptionsFlags(fs *flag.FlagSet) func() Options {
+	def := DefaultOptions()
+	dial := fs.Duration("dial-timeout", def.DialTimeout, "time to wait for the connection to the device")
+	req := fs.Duration("request-timeout", def.RequestTimeout, "time to wait for the device to reply")
+	ka := keepAliveFlag(def.KeepAlive)
+	fs.Var(&ka, "keepalive", "consider the connection dead after this long without a reply, at least 300ms, 0 disables it")
+	trace := fs.String("trace", "", "write every CoAP message exchanged with the device to this pcapng file, to open in Wireshark")
+
+	// The file is created once, however often the options are asked for
+	var (
+		once   sync.Once
+		tracer Tracer
+	)
+	return func() Options {
+		once.Do(func() {
+			if *trace != "" {
+				tracer = openTrace(*trace)
+			}
+		})
+		return Options{
+			DialTimeout:    *dial,
+			RequestTimeout: *req,
+			KeepAlive:      time.Duration(ka),
+			Trace:          tracer,
+		}
+	}
+}
+
+// openTrace returns a PcapTracer writing to file, or nil if the file
+// couldn't be created. Not being able to trace shouldn't stop what's
+// being traced, so that's only logged
+func openTrace(file string) Tracer {
+	f, err := os.Create(file)
+	if err != nil {
+		slog.Error("not tracing, failed to create trace file", "file", file, "err", err)
+		return nil
+	}
+	t, err := NewPcapTracer(f)
+	if err != nil {
+		f.Close()
+		slog.Error("not tracing", "file", file, "err", err)
+		return nil
+	}
+	return t
+}
+
+// Dial sets up a CoAP connection to a device without doing anything else.
+// Most of the time you want New instead, which also sets up a session
+func Dial(ctx context.Context, address string, opts Options) (*coap.ClientConn, error) {
+	address = NormalizeAddress(address)
+	cl := coap.Client{
+		Net:         network(address),
+		DialTimeout: opts.DialTimeout,
+	}
+	if opts.KeepAlive > 0 {
+		ka, err := coap.MakeKeepAlive(opts.KeepAlive)
+		if err != nil {
+			return nil, withKind(ErrInvalidOption, fmt.Errorf("invalid keepalive: %w", err))
+		}
+		cl.KeepAlive = ka
+	}
+
+	conn, err := cl.DialWithContext(ctx, address)
+	if err != nil {
+		return nil, withKind(ErrUnreachable, fmt.Errorf("error dialing: %w", err))
+	}
+	return conn, nil
+}
+
+// ParseOptions applies the options a device has in the configuration file
+// on top of opts: dial_timeout, request_timeout and keepalive, as
+// durations like "10s"
+func ParseOptions(opts Options, options map[string]string) (Options, error) {
+	for name, v := range options {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, withKind(ErrInvalidOption, fmt.Errorf("invalid %s %q: %w", name, v, err))
+		}
+		switch name {
+		case "dial_timeout":
+			opts.DialTimeout = d
+		case "request_timeout":
+			opts.RequestTimeout = d
+		case "keepalive":
+			if err := checkKeepAlive(d); err != nil {
+				return opts, withKind(ErrInvalidOption, err)
+			}
+			opts.KeepAlive = d
+		default:
+			return opts, withKind(ErrInvalidOption, fmt.Errorf("unknown option %q, use dial_timeout, request_timeout or keepalive", name))
+		}
+	}
+	return opts, nil
+}
+
+// checkKeepAlive returns an error if go-coap can't use d as keepalive. It
+// pings 6 times per keepalive, at least 50ms apart, so d has to be 300ms or
+// more
+func checkKeepAlive(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if _, err := coap.MakeKeepAlive(d); err != nil {
+		return fmt.Errorf("invalid keepalive %s: %w", d, err)
+	}
+	return nil
+}
+
+// keepAliveFlag is the -keepalive flag, checked with checkKeepAlive so a
+// keepalive that's too short is reported like any other bad flag
+type keepAliveFlag time.Duration
+
+func (k *keepAliveFlag) String() string {
+	return time.Duration(*k).String()
+}
+
+func (k *keepAliveFlag) Set(v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	if err := checkKeepAlive(d); err != nil {
+		return err
+	}
+	*k = keepAliveFlag(d)
+	return nil
+}
@@ -0,0 +1,51 @@
+package philips
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		options map[string]string
+		want    time.Duration
+		err     bool
+	}{
+		{map[string]string{"keepalive": "10s"}, 10 * time.Second, false},
+		{map[string]string{"keepalive": "300ms"}, 300 * time.Millisecond, false},
+		{map[string]string{"keepalive": "0"}, 0, false},
+		{map[string]string{"keepalive": "100ms"}, 0, true},
+		{map[string]string{"keepalive": "soon"}, 0, true},
+		{map[string]string{"ping": "1s"}, 0, true},
+	}
+	for _, tt := range tests {
+		opts, err := ParseOptions(DefaultOptions(), tt.options)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseOptions(%v) error = %v, want error %t", tt.options, err, tt.err)
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidOption) {
+				t.Errorf("ParseOptions(%v) error %v isn't an ErrInvalidOption", tt.options, err)
+			}
+			continue
+		}
+		if opts.KeepAlive != tt.want {
+			t.Errorf("ParseOptions(%v) keepalive = %s, want %s", tt.options, opts.KeepAlive, tt.want)
+		}
+	}
+}
+
+func TestOptionsFlags(t *testing.T) {
+	for _, v := range []string{"100ms", "soon"} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		OptionsFlags(fs)
+		if err := fs.Parse([]string{"-keepalive", v}); err == nil {
+			t.Errorf("-keepalive %s was accepted", v)
+		}
+	}
+}
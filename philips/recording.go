@@ -0,0 +1,124 @@
+package philips
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recording is a single entry in a recording of a device session, as written
+// by a Recorder. The first entry of a recording holds the device Info, every
+// entry after that holds a raw notification as it was received from the
+// device, before any decoding happened
+type Recording struct {
+	Time    time.Time `json:"time"`
+	Address string    `json:"address,omitempty"`
+	Session string    `json:"session,omitempty"`
+	Info    *Info     `json:"info,omitempty"`
+	Payload string    `json:"payload,omitempty"`
+}
+
+// Recorder writes a recording of a device session as JSON lines, one
+// Recording per line. It is safe for concurrent use
+type Recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	address string
+	session string
+}
+
+// NewRecorder returns a Recorder writing to w and records the device Info
+// as the first entry
+func NewRecorder(w io.Writer, address string, d *Device, info *Info) (*Recorder, error) {
+	r := &Recorder{
+		enc:     json.NewEncoder(w),
+		address: address,
+		session: d.Session(),
+	}
+	if err := r.write(Recording{Info: info}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record writes a raw notification to the recording
+func (r *Recorder) Record(payload []byte) error {
+	return r.write(Recording{Payload: string(payload)})
+}
+
+func (r *Recorder) write(rec Recording) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec.Time = time.Now()
+	rec.Address = r.address
+	rec.Session = r.session
+	if err := r.enc.Encode(rec); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// ReadRecording reads a recording as written by a Recorder
+func ReadRecording(r io.Reader) ([]Recording, error) {
+	var recs []Recording
+	sc := bufio.NewScanner(r)
+	// Notifications can be a couple of KB once hex encoded
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("invalid recording on line %d: %w", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return recs, nil
+}
+
+// RecordingInfo returns the device Info stored in a recording, if any
+func RecordingInfo(recs []Recording) *Info {
+	for _, rec := range recs {
+		if rec.Info != nil {
+			return rec.Info
+		}
+	}
+	return nil
+}
+
+// Replay feeds every raw notification in a recording to fn, in order. If
+// realtime is set, it waits between notifications to reproduce the timing
+// of the original session. The payloads are the same as what a Status
+// callback would've gotten from req.Msg.Payload(). Cancelling the context
+// stops the replay early
+func Replay(ctx context.Context, recs []Recording, realtime bool, fn func(payload []byte)) error {
+	var last time.Time
+	for _, rec := range recs {
+		if rec.Payload == "" {
+			continue
+		}
+		if realtime && !last.IsZero() && rec.Time.After(last) {
+			select {
+			case <-time.After(rec.Time.Sub(last)):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		last = rec.Time
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		fn([]byte(rec.Payload))
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package philips
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Settings are device settings by name, using the names and values of the
+// control subcommand, like {"mode": "sleep", "brightness": "off"}
+type Settings map[string]string
+
+// SettingNames lists the settings that can be used in Settings, with the
+// values they take
+var SettingNames = map[string]string{
+	"brightness": "on|off|25|50|75",
+	"display":    "humidity|iaq|pm25",
+	"fan":        "silent|1|2|3|turbo",
+	"function":   "humidification|purification",
+	"humidity":   "40|50|60|max",
+	"lock":       "on|yes|off|no",
+	"mode":       "auto|allergen|bacteria|manual|night|sleep",
+	"power":      "on|yes|off|no",
+}
+
+// Desired returns the command that changes all settings at once
+func (s Settings) Desired() (*Desired, error) {
+	msg := &Desired{}
+	// Sorted so the error for multiple bad settings is always the same
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := msg.Apply(name, s[name]); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Apply changes a single setting of the command, see Settings
+func (d *Desired) Apply(name, value string) error {
+	value = strings.ToLower(value)
+	invalid := func() error {
+		return fmt.Errorf("invalid %s %q, expected %s", name, value, SettingNames[name])
+	}
+
+	switch strings.ToLower(name) {
+	case "brightness":
+		var v Brightness
+		switch value {
+		case "on", "100":
+			v = Brightness100
+		case "off", "0":
+			v = Brightness0
+		case "25":
+			v = Brightness25
+		case "50":
+			v = Brightness50
+		case "75":
+			v = Brightness75
+		default:
+			return invalid()
+		}
+		d.Brightness = &v
+	case "display":
+		var v DisplayMode
+		switch value {
+		case "iaq":
+			v = IAQ
+		case "humidity":
+			v = Humidity
+		case "pm25":
+			v = PM25
+		default:
+			return invalid()
+		}
+		d.DisplayMode = &v
+	case "fan":
+		var v FanSpeed
+		switch value {
+		case "silent":
+			v = Silent
+		case "turbo":
+			v = Turbo
+		case "1":
+			v = Speed1
+		case "2":
+			v = Speed2
+		case "3":
+			v = Speed3
+		default:
+			return invalid()
+		}
+		d.FanSpeed = &v
+	case "function":
+		var v Function
+		switch value {
+		case "purification":
+			v = Purification
+		case "humidification":
+			v = PurificationHumidification
+		default:
+			return invalid()
+		}
+		d.Function = &v
+	case "humidity":
+		var v int
+		switch value {
+		case "40":
+			v = 40
+		case "50":
+			v = 50
+		case "60":
+			v = 60
+		case "max", "70":
+			v = 70
+		default:
+			return invalid()
+		}
+		d.RelativeHumidityTarget = &v
+	case "lock":
+		switch value {
+		case "on", "yes":
+			d.ChildLock = BoolP(true)
+		case "off", "no":
+			d.ChildLock = BoolP(false)
+		default:
+			return invalid()
+		}
+	case "mode":
+		var v Mode
+		switch value {
+		case "auto":
+			v = Auto
+		case "allergen":
+			v = Allergen
+		case "bacteria":
+			v = Bacteria
+		case "manual":
+			v = Manual
+		case "night":
+			v = Night
+		case "sleep":
+			v = Sleep
+		default:
+			return invalid()
+		}
+		d.Mode = &v
+	case "power":
+		var v Power
+		switch value {
+		case "on", "yes":
+			v = On
+		case "off", "no":
+			v = Off
+		default:
+			return invalid()
+		}
+		d.Power = &v
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+// Settings returns the current settings of the device, by the names and
+// values Desired.Apply takes. Values it doesn't know are left out
+func (r *Reported) Settings() Settings {
+	s := Settings{}
+	switch r.Brightness {
+	case Brightness0:
+		s["brightness"] = "off"
+	case Brightness100:
+		s["brightness"] = "on"
+	case Brightness25, Brightness50, Brightness75:
+		s["brightness"] = strconv.Itoa(int(r.Brightness))
+	}
+	switch r.DisplayMode {
+	case IAQ:
+		s["display"] = "iaq"
+	case PM25:
+		s["display"] = "pm25"
+	case Humidity:
+		s["display"] = "humidity"
+	}
+	switch r.FanSpeed {
+	case Silent:
+		s["fan"] = "silent"
+	case Turbo:
+		s["fan"] = "turbo"
+	case Speed1, Speed2, Speed3:
+		s["fan"] = string(r.FanSpeed)
+	}
+	switch r.Function {
+	case Purification:
+		s["function"] = "purification"
+	case PurificationHumidification:
+		s["function"] = "humidification"
+	}
+	switch r.RelativeHumidityTarget {
+	case 40, 50, 60:
+		s["humidity"] = strconv.Itoa(r.RelativeHumidityTarget)
+	case 70:
+		s["humidity"] = "max"
+	}
+	if r.ChildLock {
+		s["lock"] = "on"
+	} else {
+		s["lock"] = "off"
+	}
+	switch r.Mode {
+	case Auto:
+		s["mode"] = "auto"
+	case Allergen:
+		s["mode"] = "allergen"
+	case Bacteria:
+		s["mode"] = "bacteria"
+	case Manual:
+		s["mode"] = "manual"
+	case Night:
+		s["mode"] = "night"
+	case Sleep:
+		s["mode"] = "sleep"
+	}
+	switch r.Power {
+	case On:
+		s["power"] = "on"
+	case Off:
+		s["power"] = "off"
+	}
+	return s
+}
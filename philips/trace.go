@@ -0,0 +1,79 @@
+package philips
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"hemtjan.st/klimat/pcapng"
+)
+
+// Tracer gets every CoAP message exchanged with a device, see
+// Options.Trace
+type Tracer interface {
+	// Trace is called with a message that was sent to the device, or
+	// received from it if sent is false
+	Trace(sent bool, local, remote net.Addr, msg coap.Message)
+}
+
+// PcapTracer writes the messages to a pcapng file as UDP packets, with the
+// decrypted payload as the comment of encrypted ones
+type PcapTracer struct {
+	w *pcapng.Writer
+
+	// failed is set once writing failed, to only log it once
+	failed sync.Once
+}
+
+// NewPcapTracer writes the header of the file to w, and returns a
+// PcapTracer writing to it
+func NewPcapTracer(w io.Writer) (*PcapTracer, error) {
+	pw, err := pcapng.NewWriter(w, pcapng.LinkTypeRaw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write trace: %w", err)
+	}
+	return &PcapTracer{w: pw}, nil
+}
+
+// Trace writes a message to the file
+func (t *PcapTracer) Trace(sent bool, local, remote net.Addr, msg coap.Message) {
+	var buf bytes.Buffer
+	if err := msg.MarshalBinary(&buf); err != nil {
+		t.fail(err)
+		return
+	}
+	src, dst := addrPort(local), addrPort(remote)
+	if !sent {
+		src, dst = dst, src
+	}
+	comment := ""
+	if payload := msg.Payload(); len(payload) > 0 {
+		if plain, err := DecodeMessage(payload); err == nil {
+			comment = string(plain)
+		}
+	}
+	if err := t.w.WritePacket(time.Now(), pcapng.UDP(src, dst, buf.Bytes()), comment); err != nil {
+		t.fail(err)
+	}
+}
+
+func (t *PcapTracer) fail(err error) {
+	t.failed.Do(func() {
+		slog.Warn("failed to write trace, some messages will be missing", "err", err)
+	})
+}
+
+// addrPort returns the address of a UDP endpoint, or the unspecified IPv4
+// address if it isn't one
+func addrPort(a net.Addr) netip.AddrPort {
+	if u, ok := a.(*net.UDPAddr); ok && u != nil {
+		return u.AddrPort()
+	}
+	return netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+}
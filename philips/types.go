@@ -47,6 +47,42 @@ func (b Brightness) ToHemtjanst() string {
 	return strconv.Itoa(int(b))
 }
 
+// BrightnessFromHemtjanst converts a HomeKit brightness percentage to the
+// closest brightness step the device supports
+func BrightnessFromHemtjanst(v string) (Brightness, error) {
+	pct, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid brightness %q: %w", v, err)
+	}
+	if pct < 0 || pct > 100 {
+		return 0, fmt.Errorf("brightness %d out of range", pct)
+	}
+	return Brightness((pct + 12) / 25 * 25), nil
+}
+
+// HumidityTargetFromHemtjanst converts a HomeKit relative humidity
+// percentage to the closest target the device supports: 40, 50, 60 or 70,
+// which is the "max" setting of the device
+func HumidityTargetFromHemtjanst(v string) (int, error) {
+	pct, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid relative humidity %q: %w", v, err)
+	}
+	if pct < 0 || pct > 100 {
+		return 0, fmt.Errorf("relative humidity %d out of range", pct)
+	}
+	switch {
+	case pct < 45:
+		return 40, nil
+	case pct < 55:
+		return 50, nil
+	case pct < 65:
+		return 60, nil
+	default:
+		return 70, nil
+	}
+}
+
 // DisplayMode represents which value is shown on the display
 type DisplayMode string
 
@@ -54,15 +90,20 @@ type DisplayMode string
 type ErrorCode int
 
 func (e ErrorCode) String() string {
+	return fmt.Sprintf("Error: %d, %s", e, e.Description())
+}
+
+// Description returns a human readable description of the error
+func (e ErrorCode) Description() string {
 	switch e {
 	case ErrCleanFilter:
-		return fmt.Sprintf("Error: %d, one of the filters/wick needs cleaning", e)
+		return "one of the filters/wick needs cleaning"
 	case ErrNoWater:
-		return fmt.Sprintf("Error: %d, refill water tank", e)
+		return "refill water tank"
 	case ErrWaterTankOpen:
-		return fmt.Sprintf("Error: %d, water tank is open", e)
+		return "water tank is open"
 	default:
-		return fmt.Sprintf("Error: %d, unknown", e)
+		return "unknown"
 	}
 }
 
@@ -88,6 +129,44 @@ func (f FanSpeed) ToHemtjanst() string {
 	}
 }
 
+// FanSpeeds are the fan speeds from slowest to fastest
+var FanSpeeds = []FanSpeed{Silent, Speed1, Speed2, Speed3, Turbo}
+
+// Step returns where the fan speed is in FanSpeeds, or -1 for an unknown
+// speed
+func (f FanSpeed) Step() int {
+	for i, s := range FanSpeeds {
+		if s == f {
+			return i
+		}
+	}
+	return -1
+}
+
+// FanSpeedFromHemtjanst converts a HomeKit rotation speed percentage to the
+// closest fan speed the device supports. The cut-off points lie halfway
+// between the percentages ToHemtjanst returns, so the conversion round trips
+func FanSpeedFromHemtjanst(v string) (FanSpeed, error) {
+	pct, err := strconv.Atoi(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid rotation speed %q: %w", v, err)
+	}
+	switch {
+	case pct < 1 || pct > 100:
+		return "", fmt.Errorf("rotation speed %d out of range", pct)
+	case pct <= 12:
+		return Silent, nil
+	case pct <= 30:
+		return Speed1, nil
+	case pct <= 60:
+		return Speed2, nil
+	case pct <= 90:
+		return Speed3, nil
+	default:
+		return Turbo, nil
+	}
+}
+
 // Function is either purification or purification and humidification
 type Function string
 
@@ -201,7 +280,7 @@ type Reported struct {
 	// Over The Air update
 	// No idea what the value means, seeing 'ck' on one
 	OTA string `json:"ota"`
-	// Amount of hours the device has been powered on
+	// How long the device has been powered on, in milliseconds
 	Runtime     int    `json:"Runtime"`
 	WiFiVersion string `json:"WifiVersion"`
 	// Some crazy long identifier
@@ -0,0 +1,128 @@
+package philips
+
+import "testing"
+
+func TestBrightnessFromHemtjanst(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Brightness
+		err  bool
+	}{
+		{"0", Brightness0, false},
+		{"12", Brightness0, false},
+		{"13", Brightness25, false},
+		{"37", Brightness25, false},
+		{"38", Brightness50, false},
+		{"62", Brightness50, false},
+		{"63", Brightness75, false},
+		{"87", Brightness75, false},
+		{"88", Brightness100, false},
+		{"100", Brightness100, false},
+		{"-1", 0, true},
+		{"101", 0, true},
+		{"bright", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := BrightnessFromHemtjanst(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("BrightnessFromHemtjanst(%q) error = %v, want error %t", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("BrightnessFromHemtjanst(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHumidityTargetFromHemtjanst(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		err  bool
+	}{
+		{"0", 40, false},
+		{"44", 40, false},
+		{"45", 50, false},
+		{"54", 50, false},
+		{"55", 60, false},
+		{"64", 60, false},
+		{"65", 70, false},
+		{"100", 70, false},
+		{"-1", 0, true},
+		{"101", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := HumidityTargetFromHemtjanst(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("HumidityTargetFromHemtjanst(%q) error = %v, want error %t", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("HumidityTargetFromHemtjanst(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFanSpeedFromHemtjanst(t *testing.T) {
+	tests := []struct {
+		in   string
+		want FanSpeed
+		err  bool
+	}{
+		{"0", "", true},
+		{"1", Silent, false},
+		{"12", Silent, false},
+		{"13", Speed1, false},
+		{"30", Speed1, false},
+		{"31", Speed2, false},
+		{"60", Speed2, false},
+		{"61", Speed3, false},
+		{"90", Speed3, false},
+		{"91", Turbo, false},
+		{"100", Turbo, false},
+		{"101", "", true},
+		{"fast", "", true},
+	}
+	for _, tt := range tests {
+		got, err := FanSpeedFromHemtjanst(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("FanSpeedFromHemtjanst(%q) error = %v, want error %t", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FanSpeedFromHemtjanst(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFanSpeed(t *testing.T) {
+	tests := []struct {
+		speed FanSpeed
+		pct   string
+		step  int
+	}{
+		{Silent, "5", 0},
+		{Speed1, "20", 1},
+		{Speed2, "40", 2},
+		{Speed3, "80", 3},
+		{Turbo, "100", 4},
+		{"x", "0", -1},
+	}
+	for _, tt := range tests {
+		if got := tt.speed.ToHemtjanst(); got != tt.pct {
+			t.Errorf("%q.ToHemtjanst() = %q, want %q", tt.speed, got, tt.pct)
+		}
+		if got := tt.speed.Step(); got != tt.step {
+			t.Errorf("%q.Step() = %d, want %d", tt.speed, got, tt.step)
+		}
+		if tt.step < 0 {
+			continue
+		}
+		// Every speed the device reports has to survive a set request
+		// with the percentage it was published as
+		if got, err := FanSpeedFromHemtjanst(tt.pct); err != nil || got != tt.speed {
+			t.Errorf("FanSpeedFromHemtjanst(%q) = %q, %v, want %q", tt.pct, got, err, tt.speed)
+		}
+	}
+}
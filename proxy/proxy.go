@@ -0,0 +1,229 @@
+// Package proxy pretends to be a device to the vendor app, the Air Matters
+// or Clean Home+ app, while the bridge holds the only session to the real
+// device. Every client that talks to a device directly runs its own session
+// against the device's one session counter, so running the app next to the
+// bridge makes both of them flaky. Through the proxy the app gets its own
+// session with us instead, and its commands are decrypted and sent on over
+// the bridge's connection.
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+	"hemtjan.st/klimat/philips"
+)
+
+// Options configure a Proxy
+type Options struct {
+	// Address is the address to serve CoAP on, like :5683
+	Address string
+	// Info is what the proxy answers /sys/dev/info with
+	Info *philips.Info
+	// Control sends the desired state JSON object of a command from the
+	// app on to the device
+	Control func(desired []byte) error
+}
+
+// Proxy serves a device to the app
+type Proxy struct {
+	opts   Options
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// sess is the session of the app with us, it's unrelated to the one of
+	// the bridge with the device
+	sess *philips.Session
+	// last is the last decrypted status, for new observers
+	last      []byte
+	observers map[string]*observer
+	// mid is the MessageID of the last notification
+	mid uint16
+}
+
+// observer is an app observing /sys/dev/status
+type observer struct {
+	req *coap.Request
+	seq uint32
+}
+
+// New returns a Proxy
+func New(opts Options) *Proxy {
+	return &Proxy{
+		opts:      opts,
+		logger:    slog.With("subsystem", "proxy", "device", opts.Info.DeviceID),
+		sess:      philips.NewSession(),
+		observers: map[string]*observer{},
+	}
+}
+
+// Run serves the app until the context is cancelled
+func (p *Proxy) Run(ctx context.Context) error {
+	srv := &coap.Server{
+		Net:     "udp",
+		Addr:    p.opts.Address,
+		Handler: coap.HandlerFunc(p.serve),
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown()
+	}()
+	p.logger.Info("serving the device to the app", "address", p.opts.Address)
+	if err := srv.ListenAndServe(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to serve on %s: %w", p.opts.Address, err)
+	}
+	return nil
+}
+
+// Notify sends a decrypted status to every app observing the device,
+// encrypted for their session with us
+func (p *Proxy) Notify(plain []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last = append([]byte(nil), plain...)
+	for key, o := range p.observers {
+		if err := p.notify(o); err != nil {
+			p.logger.Info("app stopped observing", "client", key, "err", err)
+			delete(p.observers, key)
+		}
+	}
+}
+
+// notify sends the last status to an observer. It's called with mu held
+func (p *Proxy) notify(o *observer) error {
+	payload, err := philips.EncodeMessage(p.sess, p.last)
+	if err != nil {
+		return err
+	}
+	p.sess.Increment()
+	o.seq++
+	p.mid++
+
+	msg := o.req.Client.NewMessage(coap.MessageParams{
+		Type:      coap.NonConfirmable,
+		Code:      codes.Content,
+		MessageID: p.mid,
+		Token:     o.req.Msg.Token(),
+		Payload:   payload,
+	})
+	msg.SetOption(coap.ContentFormat, coap.AppJSON)
+	msg.SetOption(coap.Observe, o.seq)
+	return o.req.Client.WriteMsg(msg)
+}
+
+func (p *Proxy) serve(w coap.ResponseWriter, r *coap.Request) {
+	path := strings.Trim(r.Msg.PathString(), "/")
+	p.logger.Debug("request from the app", "path", path, "code", r.Msg.Code().String())
+
+	switch {
+	case path == "sys/dev/info" && r.Msg.Code() == codes.GET:
+		info, err := json.Marshal(p.opts.Info)
+		if err != nil {
+			w.SetCode(codes.InternalServerError)
+			return
+		}
+		w.SetContentFormat(coap.AppJSON)
+		w.Write(info)
+	case path == "sys/dev/sync" && r.Msg.Code() == codes.POST:
+		w.SetContentFormat(coap.TextPlain)
+		w.Write([]byte(p.session()))
+	case path == "sys/dev/status" && r.Msg.Code() == codes.GET:
+		p.status(w, r)
+	case path == "sys/dev/control" && r.Msg.Code() == codes.POST:
+		w.SetContentFormat(coap.AppJSON)
+		if err := p.control(r.Msg.Payload()); err != nil {
+			p.logger.Warn("failed to forward command from the app", "err", err)
+			w.Write([]byte(`{"status":"failed"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success"}`))
+	default:
+		w.SetCode(codes.NotFound)
+	}
+}
+
+// session returns our session ID for an app that just synced
+func (p *Proxy) session() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sess.Hex()
+}
+
+// status starts or stops observing, or answers a single request with the
+// last status
+func (p *Proxy) status(w coap.ResponseWriter, r *coap.Request) {
+	key := r.Client.RemoteAddr().String() + "/" + fmt.Sprintf("%x", r.Msg.Token())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if observe(r.Msg) == 1 {
+		delete(p.observers, key)
+		p.logger.Info("app stopped observing", "client", key)
+	}
+	// The app retries until the bridge got the first status from the
+	// device
+	if p.last == nil {
+		w.SetCode(codes.ServiceUnavailable)
+		return
+	}
+	payload, err := philips.EncodeMessage(p.sess, p.last)
+	if err != nil {
+		w.SetCode(codes.InternalServerError)
+		return
+	}
+	p.sess.Increment()
+
+	msg := w.NewResponse(codes.Content)
+	msg.SetOption(coap.ContentFormat, coap.AppJSON)
+	if observe(r.Msg) == 0 {
+		o := &observer{req: r}
+		p.observers[key] = o
+		msg.SetOption(coap.Observe, o.seq)
+		p.logger.Info("app started observing", "client", key)
+	}
+	msg.SetPayload(payload)
+	if err := w.WriteMsg(msg); err != nil {
+		p.logger.Warn("failed to answer the app", "client", key, "err", err)
+	}
+}
+
+// observe returns the value of the Observe option, or -1 if it isn't set
+func observe(msg coap.Message) int {
+	switch v := msg.Option(coap.Observe).(type) {
+	case uint32:
+		return int(v)
+	case int:
+		return v
+	default:
+		return -1
+	}
+}
+
+// control decrypts a command from the app and sends its desired state on
+func (p *Proxy) control(payload []byte) error {
+	plain, err := philips.DecodeMessage(payload)
+	if err != nil {
+		return err
+	}
+	var cmd struct {
+		State struct {
+			Desired json.RawMessage `json:"desired"`
+		} `json:"state"`
+	}
+	if err := json.Unmarshal(plain, &cmd); err != nil {
+		return fmt.Errorf("could not decode command: %w", err)
+	}
+	if len(cmd.State.Desired) == 0 {
+		return fmt.Errorf("command has no desired state: %s", plain)
+	}
+	p.logger.Info("forwarding command from the app", "state", string(cmd.State.Desired))
+	return p.opts.Control(cmd.State.Desired)
+}
@@ -0,0 +1,62 @@
+package sniff
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Capture calls fn with every Ethernet frame sent or received on the
+// interface, until the context is cancelled. It needs root or
+// CAP_NET_RAW. Only traffic that passes the interface can be seen, so to
+// follow a phone talking to a device run it on the router or a mirror port
+func Capture(ctx context.Context, iface string, fn func(t time.Time, frame []byte)) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return fmt.Errorf("unknown interface %s: %w", iface, err)
+	}
+
+	proto := htons(syscall.ETH_P_ALL)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return fmt.Errorf("failed to open capture socket, this needs root or CAP_NET_RAW: %w", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		return fmt.Errorf("failed to capture on %s: %w", iface, err)
+	}
+	// Wake up every now and then to see if we should stop
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("failed to set capture timeout: %w", err)
+	}
+
+	loopback := ifi.Flags&net.FlagLoopback != 0
+	buf := make([]byte, 65536)
+	for ctx.Err() == nil {
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return fmt.Errorf("failed to capture: %w", err)
+		}
+		// On loopback every frame is seen going out and coming back in
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && loopback && ll.Pkttype == packetOutgoing {
+			continue
+		}
+		// Events hold on to parts of the frame
+		fn(time.Now(), append([]byte(nil), buf[:n]...))
+	}
+	return nil
+}
+
+// packetOutgoing is PACKET_OUTGOING, the type of frames we sent
+const packetOutgoing = 4
+
+// htons converts a short to network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
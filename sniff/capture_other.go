@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sniff
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Capture is only supported on Linux
+func Capture(ctx context.Context, iface string, fn func(t time.Time, frame []byte)) error {
+	return errors.New("capturing is only supported on Linux")
+}
@@ -0,0 +1,135 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CoAP option numbers we look at
+const (
+	optObserve = 6
+	optURIPath = 11
+)
+
+// Message is a CoAP message as seen on the wire
+type Message struct {
+	Type      uint8
+	Code      uint8
+	MessageID uint16
+	Token     []byte
+	// Path is the Uri-Path, empty for responses
+	Path string
+	// Observe is the Observe option, or -1 if it's not set
+	Observe int
+	Payload []byte
+}
+
+// ParseMessage parses a CoAP message, RFC 7252
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < 4 {
+		return nil, errors.New("message too short")
+	}
+	if b[0]>>6 != 1 {
+		return nil, fmt.Errorf("unknown CoAP version %d", b[0]>>6)
+	}
+	m := &Message{
+		Type:      (b[0] >> 4) & 0x03,
+		Code:      b[1],
+		MessageID: binary.BigEndian.Uint16(b[2:4]),
+		Observe:   -1,
+	}
+	tkl := int(b[0] & 0x0f)
+	b = b[4:]
+	if tkl > 8 || len(b) < tkl {
+		return nil, errors.New("invalid token")
+	}
+	m.Token = b[:tkl]
+	b = b[tkl:]
+
+	var path []string
+	opt := 0
+	for len(b) > 0 {
+		if b[0] == 0xff {
+			m.Payload = b[1:]
+			break
+		}
+		delta, length := int(b[0]>>4), int(b[0]&0x0f)
+		b = b[1:]
+		var err error
+		if delta, b, err = optionValue(delta, b); err != nil {
+			return nil, err
+		}
+		if length, b, err = optionValue(length, b); err != nil {
+			return nil, err
+		}
+		if len(b) < length {
+			return nil, errors.New("option too long")
+		}
+		opt += delta
+		value := b[:length]
+		b = b[length:]
+
+		switch opt {
+		case optURIPath:
+			path = append(path, string(value))
+		case optObserve:
+			m.Observe = 0
+			for _, v := range value {
+				m.Observe = m.Observe<<8 | int(v)
+			}
+		}
+	}
+	if len(path) > 0 {
+		m.Path = "/" + strings.Join(path, "/")
+	}
+	return m, nil
+}
+
+// optionValue decodes the extended option delta or length that follows
+// the first byte of an option
+func optionValue(v int, b []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errors.New("option truncated")
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errors.New("option truncated")
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errors.New("invalid option")
+	}
+	return v, b, nil
+}
+
+// TypeString returns the type as CON, NON, ACK or RST
+func (m *Message) TypeString() string {
+	return [...]string{"CON", "NON", "ACK", "RST"}[m.Type]
+}
+
+// CodeString returns the code, like GET or 2.05
+func (m *Message) CodeString() string {
+	switch m.Code {
+	case 0:
+		return "EMPTY"
+	case 1:
+		return "GET"
+	case 2:
+		return "POST"
+	case 3:
+		return "PUT"
+	case 4:
+		return "DELETE"
+	}
+	return fmt.Sprintf("%d.%02d", m.Code>>5, m.Code&0x1f)
+}
+
+// IsRequest reports if the message is a request
+func (m *Message) IsRequest() bool {
+	return m.Code >= 1 && m.Code < 32
+}
@@ -0,0 +1,68 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	protoUDP      = 17
+)
+
+// parseFrame returns the addresses and payload of a UDP datagram in an
+// Ethernet frame. Anything else, and fragmented datagrams, are skipped
+func parseFrame(b []byte) (src, dst netip.AddrPort, payload []byte, ok bool) {
+	if len(b) < 14 {
+		return src, dst, nil, false
+	}
+	etherType := binary.BigEndian.Uint16(b[12:14])
+	b = b[14:]
+	if etherType == etherTypeVLAN {
+		if len(b) < 4 {
+			return src, dst, nil, false
+		}
+		etherType = binary.BigEndian.Uint16(b[2:4])
+		b = b[4:]
+	}
+
+	var srcIP, dstIP netip.Addr
+	switch etherType {
+	case etherTypeIPv4:
+		if len(b) < 20 {
+			return src, dst, nil, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		// More fragments set, or a fragment offset
+		fragmented := binary.BigEndian.Uint16(b[6:8])&0x3fff != 0
+		if ihl < 20 || len(b) < ihl || b[9] != protoUDP || fragmented {
+			return src, dst, nil, false
+		}
+		srcIP = netip.AddrFrom4([4]byte(b[12:16]))
+		dstIP = netip.AddrFrom4([4]byte(b[16:20]))
+		b = b[ihl:]
+	case etherTypeIPv6:
+		// Extension headers aren't followed, the devices don't use them
+		if len(b) < 40 || b[6] != protoUDP {
+			return src, dst, nil, false
+		}
+		srcIP = netip.AddrFrom16([16]byte(b[8:24]))
+		dstIP = netip.AddrFrom16([16]byte(b[24:40]))
+		b = b[40:]
+	default:
+		return src, dst, nil, false
+	}
+
+	if len(b) < 8 {
+		return src, dst, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(b[4:6]))
+	if length < 8 || length > len(b) {
+		return src, dst, nil, false
+	}
+	src = netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(b[0:2]))
+	dst = netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(b[2:4]))
+	return src, dst, b[8:length], true
+}
@@ -0,0 +1,139 @@
+// Package sniff decodes the CoAP traffic between devices and their clients,
+// like the vendor app, from captured Ethernet frames. The session IDs are
+// picked up from the sync exchange and the encrypted payloads decrypted, so
+// the protocol can be followed live without Wireshark.
+package sniff
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"hemtjan.st/klimat/philips"
+)
+
+// Event is a CoAP message to or from a device
+type Event struct {
+	Time time.Time
+	Src  netip.AddrPort
+	Dst  netip.AddrPort
+	Msg  *Message
+	// Plain is the decrypted payload, if the payload was encrypted
+	Plain []byte
+	// Session is the session ID the payload was encrypted with, or the one
+	// sent during the sync exchange
+	Session string
+	// Note points out something of interest, like a session being
+	// established or its counter skipping
+	Note string
+}
+
+// Decoder turns captured frames into Events. It keeps track of the sessions
+// between clients and devices, so it has to see all frames in order
+type Decoder struct {
+	port uint16
+
+	mu sync.Mutex
+	// syncs are the session IDs of sync requests waiting for a response,
+	// by exchange
+	syncs map[string]string
+	// next is the session ID the client should use next, by client and
+	// device
+	next map[string]uint32
+}
+
+// NewDecoder returns a Decoder for devices listening on port, usually 5683
+func NewDecoder(port uint16) *Decoder {
+	return &Decoder{
+		port:  port,
+		syncs: map[string]string{},
+		next:  map[string]uint32{},
+	}
+}
+
+// Decode returns the Event for a captured Ethernet frame, or false if the
+// frame isn't CoAP to or from port
+func (d *Decoder) Decode(t time.Time, frame []byte) (*Event, bool) {
+	src, dst, payload, ok := parseFrame(frame)
+	if !ok || (src.Port() != d.port && dst.Port() != d.port) {
+		return nil, false
+	}
+	msg, err := ParseMessage(payload)
+	if err != nil {
+		return nil, false
+	}
+	ev := &Event{Time: t, Src: src, Dst: dst, Msg: msg}
+
+	// The device is the one on the CoAP port
+	client, device := src, dst
+	if src.Port() == d.port {
+		client, device = dst, src
+	}
+	pair := client.String() + ">" + device.String()
+	exchange := pair + "/" + string(msg.Token)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case msg.IsRequest() && msg.Path == "/sys/dev/sync":
+		ev.Session = string(msg.Payload)
+		d.syncs[exchange] = ev.Session
+		ev.Note = "client sent its session ID"
+	case !msg.IsRequest() && d.syncs[exchange] != "":
+		delete(d.syncs, exchange)
+		ev.Session = string(msg.Payload)
+		if id, err := strconv.ParseUint(ev.Session, 16, 32); err == nil {
+			d.next[pair] = uint32(id) + 1
+			ev.Note = "device sent its session ID, commands start at " + sessionHex(uint32(id)+1)
+		}
+	case encrypted(msg.Payload):
+		plain, err := philips.DecodeMessage(msg.Payload)
+		if err != nil {
+			ev.Note = "failed to decrypt: " + err.Error()
+			break
+		}
+		ev.Plain = plain
+		ev.Session = string(msg.Payload[:8])
+		if msg.IsRequest() && msg.Path == "/sys/dev/control" {
+			ev.Note = d.checkCounter(pair, ev.Session)
+		}
+	}
+	return ev, true
+}
+
+// checkCounter compares the session ID of a command with the one the
+// client should be using, which is where sessions go out of sync
+func (d *Decoder) checkCounter(pair, session string) string {
+	id, err := strconv.ParseUint(session, 16, 32)
+	if err != nil {
+		return ""
+	}
+	next, known := d.next[pair]
+	d.next[pair] = uint32(id) + 1
+	if known && uint32(id) != next {
+		return "session ID " + session + " where " + sessionHex(next) + " was expected"
+	}
+	return ""
+}
+
+// encrypted reports if a payload looks like an encrypted message: a session
+// ID, at least one block of ciphertext and a checksum, all in hex
+func encrypted(payload []byte) bool {
+	if len(payload) < 8+32+64 || len(payload)%2 != 0 {
+		return false
+	}
+	for _, c := range payload {
+		if !('0' <= c && c <= '9' || 'A' <= c && c <= 'F' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionHex formats a session ID like the devices do
+func sessionHex(id uint32) string {
+	return fmt.Sprintf("%08X", id)
+}
@@ -0,0 +1,39 @@
+package stream
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// serveEvents streams the updates of a device as Server-Sent Events, one
+// "status" event per update
+func (h *Hub) serveEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies like nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	updates, stop := h.subscribe(id)
+	defer stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case update := <-updates:
+			// Updates are single line JSON, so they fit in one data field
+			if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", update); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package stream pushes the status updates of devices to HTTP clients as
+// they come in.
+package stream
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// buffer is how many updates a client can fall behind before updates are
+// dropped for it
+const buffer = 16
+
+// Hub fans out the status updates of devices to everyone streaming them
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan []byte]struct{}
+}
+
+// NewHub returns an empty Hub
+func NewHub() *Hub {
+	return &Hub{subs: map[string]map[chan []byte]struct{}{}}
+}
+
+// Publish sends a status update of a device to everyone streaming it. A
+// client that can't keep up misses the update, instead of holding up the
+// bridge
+func (h *Hub) Publish(deviceID string, update []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[deviceID] {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving the updates of a device, and a
+// function to stop receiving them
+func (h *Hub) subscribe(deviceID string) (<-chan []byte, func()) {
+	ch := make(chan []byte, buffer)
+	h.mu.Lock()
+	if h.subs[deviceID] == nil {
+		h.subs[deviceID] = map[chan []byte]struct{}{}
+	}
+	h.subs[deviceID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[deviceID], ch)
+		if len(h.subs[deviceID]) == 0 {
+			delete(h.subs, deviceID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// ServeHTTP streams the updates of a device over a WebSocket on
+// /devices/<DeviceID>/stream, or as Server-Sent Events on
+// /devices/<DeviceID>/events
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, kind, ok := parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch kind {
+	case "stream":
+		h.serveWebSocket(w, r, id)
+	case "events":
+		h.serveEvents(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// parsePath splits /devices/<id>/<kind> into its parts
+func parsePath(path string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/devices/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,190 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The bits of RFC 6455 we need to push text messages to a client. There's
+// no support for fragmented messages from the client, we only ever read
+// control frames from it
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// writeTimeout is how long a client gets to accept a message
+const writeTimeout = 10 * time.Second
+
+func (h *Hub) serveWebSocket(w http.ResponseWriter, r *http.Request, id string) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets aren't supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn}
+	updates, stop := h.subscribe(id)
+	defer stop()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readControl(rw.Reader)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case update := <-updates:
+			if err := ws.write(opText, update); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type wsConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// write sends an unfragmented, unmasked frame
+func (c *wsConn) write(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 126, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr = append(hdr, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readControl reads frames from the client until it closes the connection,
+// answering pings along the way. Anything else the client sends is ignored
+func (c *wsConn) readControl(r *bufio.Reader) {
+	for {
+		op, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch op {
+		case opClose:
+			c.write(opClose, nil)
+			return
+		case opPing:
+			if c.write(opPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// maxFrame is the largest frame we accept from a client, it has no reason
+// to send anything big
+const maxFrame = 4096
+
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	op := hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxFrame {
+		return 0, nil, errors.New("frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return op, payload, nil
+}
+
+// headerContains reports if a comma separated header contains a token,
+// ignoring case
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+// Package webhook sends notifications to an HTTP endpoint. Both the URL and
+// the body are text/template templates that get the notification as their
+// data.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultBody is the body template used when none is given. It sends the
+// notification as JSON
+const DefaultBody = "{{json .}}"
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Webhook posts notifications to a URL
+type Webhook struct {
+	url    *template.Template
+	body   *template.Template
+	client *http.Client
+}
+
+// New returns a Webhook for a URL and body template. An empty body uses
+// DefaultBody
+func New(url, body string) (*Webhook, error) {
+	if body == "" {
+		body = DefaultBody
+	}
+	u, err := template.New("url").Funcs(funcs).Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL template: %w", err)
+	}
+	b, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook body template: %w", err)
+	}
+	return &Webhook{
+		url:    u,
+		body:   b,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Send renders the templates with data and posts the result
+func (w *Webhook) Send(ctx context.Context, data interface{}) error {
+	var url, body bytes.Buffer
+	if err := w.url.Execute(&url, data); err != nil {
+		return fmt.Errorf("failed to render webhook URL: %w", err)
+	}
+	if err := w.body.Execute(&body, data); err != nil {
+		return fmt.Errorf("failed to render webhook body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSpace(url.String()), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}